@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+)
+
+// payeeNameRE matches the names that may be given to payees in the address
+// book.
+var payeeNameRE = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]{0,63}$`)
+
+// addrBookChange records a change made to the address book.
+type addrBookChange struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Name       string    `json:"name"`
+	OldAddress string    `json:"oldaddress,omitempty"`
+	NewAddress string    `json:"newaddress,omitempty"`
+}
+
+// addrBook maps payee names to the addresses that receive their payouts.
+type addrBook struct {
+	Network string            `json:"network"`
+	Payees  map[string]string `json:"payees"`
+	Changes []addrBookChange  `json:"changes"`
+
+	path  string
+	addrs map[string]stdaddr.StakeAddress
+}
+
+// validatePayeeName returns an error if name is not a valid payee name. Names
+// that decode as addresses are rejected so that a payout entry is never
+// ambiguous.
+func validatePayeeName(name string, chainParams *chaincfg.Params) error {
+	if !payeeNameRE.MatchString(name) {
+		return fmt.Errorf("invalid payee name %q: names must start with a "+
+			"letter and contain only letters, digits, '_', '.' and '-'",
+			name)
+	}
+	if _, err := stdaddr.DecodeAddress(name, chainParams); err == nil {
+		return fmt.Errorf("invalid payee name %q: names cannot be "+
+			"addresses", name)
+	}
+	return nil
+}
+
+// loadAddrBook loads the address book at the given path, validating every
+// entry. A missing file is an empty address book.
+func loadAddrBook(path string, chainParams *chaincfg.Params) (*addrBook, error) {
+	book := &addrBook{
+		Network: chainParams.Name,
+		Payees:  make(map[string]string),
+		path:    path,
+		addrs:   make(map[string]stdaddr.StakeAddress),
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, book); err != nil {
+		return nil, fmt.Errorf("unable to decode address book %s: %v",
+			path, err)
+	}
+	if book.Network != chainParams.Name {
+		return nil, fmt.Errorf("address book %s is for network %s",
+			path, book.Network)
+	}
+	if book.Payees == nil {
+		book.Payees = make(map[string]string)
+	}
+	for name, encodedAddr := range book.Payees {
+		if err := validatePayeeName(name, chainParams); err != nil {
+			return nil, fmt.Errorf("address book %s: %v", path, err)
+		}
+		addr, err := parseStakeAddress(encodedAddr, chainParams)
+		if err != nil {
+			return nil, fmt.Errorf("address book %s: payee %s: %v",
+				path, name, err)
+		}
+		book.addrs[name] = addr
+	}
+	return book, nil
+}
+
+// save writes the address book back to its file.
+func (book *addrBook) save() error {
+	b, err := json.MarshalIndent(book, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if err := os.WriteFile(book.path, b, 0600); err != nil {
+		return fmt.Errorf("unable to write address book: %v", err)
+	}
+	return nil
+}
+
+// set adds or replaces the entry of a payee, recording the change.
+func (book *addrBook) set(action, name string, addr stdaddr.StakeAddress) {
+	book.Changes = append(book.Changes, addrBookChange{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		Name:       name,
+		OldAddress: book.Payees[name],
+		NewAddress: addr.String(),
+	})
+	book.Payees[name] = addr.String()
+	book.addrs[name] = addr
+}
+
+// resolvePayee resolves a payout recipient, which is either an address or the
+// name of a payee in the address book. It returns the name of the payee (empty
+// for raw addresses) and its address.
+func resolvePayee(cfg *config, s string) (string, stdaddr.StakeAddress, error) {
+	if cfg.addrBook != nil {
+		if addr, ok := cfg.addrBook.addrs[s]; ok {
+			return s, addr, nil
+		}
+	}
+	addr, err := parseStakeAddress(s, cfg.chainParams)
+	if err != nil && payeeNameRE.MatchString(s) {
+		return "", nil, fmt.Errorf("%q is neither an address nor a "+
+			"payee in the address book", s)
+	}
+	return "", addr, err
+}
+
+// payeeString returns the description of a payout recipient used in the
+// review output, with the payee name when known.
+func payeeString(name string, addr stdaddr.StakeAddress) string {
+	if name == "" {
+		return addr.String()
+	}
+	return fmt.Sprintf("%s (%s)", name, addr)
+}
+
+// runAddrBookCmd runs the addrbook command, which lists the address book or
+// changes its entries. Adding a payee never replaces an existing entry; that
+// requires the explicit update command.
+func runAddrBookCmd(cfg *config, args []string) error {
+	book, err := loadAddrBook(cfg.AddrBook, cfg.chainParams)
+	if err != nil {
+		return err
+	}
+
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+		args = args[1:]
+	}
+	switch action {
+	case "list":
+		names := make([]string, 0, len(book.Payees))
+		for name := range book.Payees {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%-24s %s\n", name, book.Payees[name])
+		}
+		return nil
+
+	case "add", "update":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: addrbook %s <name> <address>", action)
+		}
+		name := args[0]
+		if err := validatePayeeName(name, cfg.chainParams); err != nil {
+			return err
+		}
+		addr, err := parseStakeAddress(args[1], cfg.chainParams)
+		if err != nil {
+			return fmt.Errorf("invalid address for payee %s: %v", name, err)
+		}
+		old, exists := book.Payees[name]
+		switch {
+		case action == "add" && exists:
+			return fmt.Errorf("payee %s already exists with address %s; "+
+				"use the update command to change it", name, old)
+		case action == "update" && !exists:
+			return fmt.Errorf("payee %s does not exist; use the add "+
+				"command to create it", name)
+		case old == addr.String():
+			return fmt.Errorf("payee %s already has address %s", name, old)
+		}
+		book.set(action, name, addr)
+		if err := book.save(); err != nil {
+			return err
+		}
+		if action == "add" {
+			log.Infof("Added payee %s with address %s", name, addr)
+		} else {
+			log.Infof("Updated payee %s from address %s to %s", name,
+				old, addr)
+		}
+		return nil
+
+	case "remove":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: addrbook remove <name>")
+		}
+		name := args[0]
+		old, exists := book.Payees[name]
+		if !exists {
+			return fmt.Errorf("payee %s does not exist", name)
+		}
+		book.Changes = append(book.Changes, addrBookChange{
+			Time:       time.Now().UTC(),
+			Action:     action,
+			Name:       name,
+			OldAddress: old,
+		})
+		delete(book.Payees, name)
+		delete(book.addrs, name)
+		if err := book.save(); err != nil {
+			return err
+		}
+		log.Infof("Removed payee %s with address %s", name, old)
+		return nil
+	}
+	return fmt.Errorf("unknown addrbook command %q", action)
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+	"github.com/decred/dcrd/wire"
+)
+
+// signedMessagePrefix is the prefix of messages signed with the Decred message
+// signing scheme (as used by the signmessage and verifymessage RPCs).
+const signedMessagePrefix = "Decred Signed Message:\n"
+
+// approval is the signature of a reviewer over the canonical form of an
+// unsigned manifest.
+type approval struct {
+	Reviewer  string `json:"reviewer,omitempty"`
+	Address   string `json:"address"`
+	Signature string `json:"signature"`
+}
+
+// unsignedManifest describes an unsigned tspend so that it can be reviewed and
+// approved before it is signed with the Pi key.
+type unsignedManifest struct {
+	Network     string            `json:"network"`
+	TxHash      string            `json:"txhash"`
+	SigHash     string            `json:"sighash"`
+	Expiry      uint32            `json:"expiry"`
+	Fee         dcrutil.Amount    `json:"fee"`
+	Total       dcrutil.Amount    `json:"total"`
+	Payouts     []manifestPayout  `json:"payouts"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Conversions []*fiatConversion `json:"conversions,omitempty"`
+	Tx          string            `json:"tx"`
+
+	// Approvals are not part of the canonical form of the manifest.
+	Approvals []*approval `json:"approvals,omitempty"`
+}
+
+// reviewer is a reviewer trusted to approve tspends.
+type reviewer struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// trustedReviewers is the set of reviewers whose approvals are accepted and
+// the number of approvals required to sign a tspend.
+type trustedReviewers struct {
+	Network   string     `json:"network"`
+	Required  int        `json:"required"`
+	Reviewers []reviewer `json:"reviewers"`
+
+	path string
+}
+
+// reviewersPath returns the path of the trusted reviewers file of the given
+// network. Like the signing policy, its path is fixed.
+func reviewersPath(net chainNetwork) string {
+	return filepath.Join(defaultConfigDir, string(net)+"-reviewers.json")
+}
+
+// loadTrustedReviewers loads and validates the trusted reviewers file at the
+// given path. It returns nil if there is no such file.
+func loadTrustedReviewers(path string, chainParams *chaincfg.Params) (*trustedReviewers, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	trusted := &trustedReviewers{path: path}
+	if err := json.Unmarshal(b, trusted); err != nil {
+		return nil, fmt.Errorf("unable to decode trusted reviewers %s: %v",
+			path, err)
+	}
+	if trusted.Network != chainParams.Name {
+		return nil, fmt.Errorf("trusted reviewers %s are for network %s",
+			path, trusted.Network)
+	}
+	if trusted.Required < 1 || trusted.Required > len(trusted.Reviewers) {
+		return nil, fmt.Errorf("trusted reviewers %s: %d approvals "+
+			"required out of %d reviewers", path, trusted.Required,
+			len(trusted.Reviewers))
+	}
+	seen := make(map[string]bool, len(trusted.Reviewers))
+	for _, r := range trusted.Reviewers {
+		addr, err := stdaddr.DecodeAddress(r.Address, chainParams)
+		if err != nil {
+			return nil, fmt.Errorf("trusted reviewers %s: reviewer %s: %v",
+				path, r.Name, err)
+		}
+		if _, ok := addr.(*stdaddr.AddressPubKeyHashEcdsaSecp256k1V0); !ok {
+			return nil, fmt.Errorf("trusted reviewers %s: reviewer %s: "+
+				"address is not a secp256k1 pubkey hash address",
+				path, r.Name)
+		}
+		if seen[r.Address] {
+			return nil, fmt.Errorf("trusted reviewers %s: address %s "+
+				"listed more than once", path, r.Address)
+		}
+		seen[r.Address] = true
+	}
+	return trusted, nil
+}
+
+// requireNoReviewers returns an error if trusted reviewers are configured, in
+// which case tspends may only be signed from approved manifests.
+func requireNoReviewers(cfg *config) error {
+	trusted, err := loadTrustedReviewers(reviewersPath(cfg.activeNet),
+		cfg.chainParams)
+	if err != nil {
+		return err
+	}
+	if trusted != nil {
+		return fmt.Errorf("tspends require the approval of %d trusted "+
+			"reviewers: generate the unsigned manifest with --unsigned, "+
+			"add the approvals with the approve command and sign it "+
+			"with the sign command", trusted.Required)
+	}
+	return nil
+}
+
+// signedMessageHash returns the hash that is signed by the Decred message
+// signing scheme for the given message.
+func signedMessageHash(message string) []byte {
+	var buf bytes.Buffer
+	wire.WriteVarString(&buf, 0, signedMessagePrefix)
+	wire.WriteVarString(&buf, 0, message)
+	return chainhash.HashB(buf.Bytes())
+}
+
+// verifySignedMessage returns whether the base64 encoded compact signature is
+// a valid signature of message by the key of the given address.
+func verifySignedMessage(chainParams *chaincfg.Params, address, signature,
+	message string) error {
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	pubKey, wasCompressed, err := ecdsa.RecoverCompact(sig,
+		signedMessageHash(message))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	var serializedPubKey []byte
+	if wasCompressed {
+		serializedPubKey = pubKey.SerializeCompressed()
+	} else {
+		serializedPubKey = pubKey.SerializeUncompressed()
+	}
+	addr, err := stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(
+		stdaddr.Hash160(serializedPubKey), chainParams)
+	if err != nil {
+		return err
+	}
+	if addr.String() != address {
+		return fmt.Errorf("signature is not from %s", address)
+	}
+	return nil
+}
+
+// approvalMessage returns the message reviewers sign to approve the manifest:
+// the blake256 hash of its canonical form, which is its compact JSON encoding
+// without the approvals.
+func (m *unsignedManifest) approvalMessage() (string, error) {
+	canonical := *m
+	canonical.Approvals = nil
+	b, err := json.Marshal(&canonical)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tspend approval %x", chainhash.HashB(b)), nil
+}
+
+// newUnsignedManifest returns the unsigned manifest of the given tspend.
+func newUnsignedManifest(cfg *config, msgTx *wire.MsgTx, payouts []*payout,
+	fee dcrutil.Amount) (*unsignedManifest, error) {
+
+	sigHash, err := txscript.CalcSignatureHash(nil, txscript.SigHashAll,
+		msgTx, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	rawTx, err := msgTx.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	m := &unsignedManifest{
+		Network:     cfg.chainParams.Name,
+		TxHash:      msgTx.TxHash().String(),
+		SigHash:     hex.EncodeToString(sigHash),
+		Expiry:      msgTx.Expiry,
+		Fee:         fee,
+		Total:       dcrutil.Amount(msgTx.TxIn[0].ValueIn) - fee,
+		Payouts:     manifestPayouts(payouts),
+		Metadata:    cfg.meta,
+		Conversions: fiatConversions(payouts),
+		Tx:          hex.EncodeToString(rawTx),
+	}
+	return m, nil
+}
+
+// manifestPayouts returns the payouts as recorded in manifests.
+func manifestPayouts(payouts []*payout) []manifestPayout {
+	res := make([]manifestPayout, 0, len(payouts))
+	for _, p := range payouts {
+		res = append(res, manifestPayout{
+			Name:        p.name,
+			Address:     p.address.String(),
+			Fingerprint: addrFingerprint(p.address),
+			Amount:      p.amount,
+		})
+	}
+	return res
+}
+
+// loadUnsignedManifest loads an unsigned manifest and checks that it describes
+// exactly the tspend it contains, so that approving the manifest is the same
+// as approving the tspend. It returns the manifest, the unsigned tspend and its
+// payouts.
+func loadUnsignedManifest(cfg *config, path string) (*unsignedManifest, *wire.MsgTx, []*payout, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var m unsignedManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to decode manifest: %v", err)
+	}
+	if m.Network != cfg.chainParams.Name {
+		return nil, nil, nil, fmt.Errorf("manifest is for network %s",
+			m.Network)
+	}
+
+	rawTx, err := hex.DecodeString(m.Tx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid manifest tx: %v", err)
+	}
+	var msgTx wire.MsgTx
+	if err := msgTx.FromBytes(rawTx); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid manifest tx: %v", err)
+	}
+	if len(msgTx.TxIn) != 1 || len(msgTx.TxIn[0].SignatureScript) != 0 {
+		return nil, nil, nil, fmt.Errorf("manifest tx is not an unsigned " +
+			"tspend")
+	}
+	payouts, err := tspendPayouts(&msgTx, cfg.chainParams)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i, p := range payouts {
+		if i < len(m.Payouts) {
+			p.name = m.Payouts[i].Name
+		}
+	}
+
+	// The manifest fields must match the tx.
+	want, err := newUnsignedManifest(cfg, &msgTx, payouts,
+		dcrutil.Amount(msgTx.TxIn[0].ValueIn)-sumPayouts(payouts))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	want.Metadata, want.Conversions = m.Metadata, m.Conversions
+	want.Approvals = m.Approvals
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gotJSON, err := json.Marshal(&m)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !bytes.Equal(wantJSON, gotJSON) {
+		return nil, nil, nil, fmt.Errorf("manifest does not match the " +
+			"tspend it contains")
+	}
+	return &m, &msgTx, payouts, nil
+}
+
+// sumPayouts returns the total amount of the payouts.
+func sumPayouts(payouts []*payout) dcrutil.Amount {
+	var total dcrutil.Amount
+	for _, p := range payouts {
+		total += p.amount
+	}
+	return total
+}
+
+// verifyApprovals verifies that the manifest carries valid approvals from at
+// least the required number of distinct trusted reviewers.
+func verifyApprovals(cfg *config, trusted *trustedReviewers, m *unsignedManifest) error {
+	message, err := m.approvalMessage()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(trusted.Reviewers))
+	for _, r := range trusted.Reviewers {
+		names[r.Address] = r.Name
+	}
+
+	approved := make(map[string]bool)
+	for _, a := range m.Approvals {
+		name, ok := names[a.Address]
+		if !ok {
+			log.Warnf("Ignoring approval from untrusted address %s",
+				a.Address)
+			continue
+		}
+		err := verifySignedMessage(cfg.chainParams, a.Address,
+			a.Signature, message)
+		if err != nil {
+			log.Warnf("Ignoring invalid approval from %s (%s): %v",
+				name, a.Address, err)
+			continue
+		}
+		if !approved[a.Address] {
+			log.Infof("Approved by %s (%s)", name, a.Address)
+		}
+		approved[a.Address] = true
+	}
+	if len(approved) < trusted.Required {
+		return fmt.Errorf("manifest has %d valid approvals out of the %d "+
+			"required by %s", len(approved), trusted.Required,
+			trusted.path)
+	}
+	return nil
+}
+
+// writeUnsignedManifest writes the unsigned manifest of a tspend for review
+// instead of signing it.
+func writeUnsignedManifest(cfg *config, msgTx *wire.MsgTx, payouts []*payout,
+	fee dcrutil.Amount) error {
+
+	m, err := newUnsignedManifest(cfg, msgTx, payouts, fee)
+	if err != nil {
+		return err
+	}
+	if err := writeManifest(cfg.Unsigned, m); err != nil {
+		return err
+	}
+	message, err := m.approvalMessage()
+	if err != nil {
+		return err
+	}
+	log.Infof("Wrote unsigned manifest of tspend %s to %s", m.TxHash,
+		cfg.Unsigned)
+	log.Infof("Approval message: %s", message)
+	return nil
+}
+
+// runApproveCmd runs the approve command, which adds the approval of a
+// reviewer to an unsigned manifest. The approval is either signed with the
+// reviewer key given in the config or produced elsewhere (such as with the
+// signmessage command of a wallet) and given with its address. Without either,
+// the message to sign is printed.
+func runApproveCmd(cfg *config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: approve <manifest>")
+	}
+	path := args[0]
+	m, _, _, err := loadUnsignedManifest(cfg, path)
+	if err != nil {
+		return err
+	}
+	message, err := m.approvalMessage()
+	if err != nil {
+		return err
+	}
+
+	a := &approval{Reviewer: cfg.Reviewer, Signature: cfg.ApprovalSig}
+	switch {
+	case cfg.ReviewerKey != "":
+		wif, err := dcrutil.DecodeWIF(strings.TrimSpace(cfg.ReviewerKey),
+			cfg.chainParams.PrivateKeyID)
+		if err != nil {
+			return fmt.Errorf("invalid reviewer key: %v", err)
+		}
+		addr, err := stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(
+			stdaddr.Hash160(wif.PubKey()), cfg.chainParams)
+		if err != nil {
+			return err
+		}
+		privKey := secp256k1.PrivKeyFromBytes(wif.PrivKey())
+		sig := ecdsa.SignCompact(privKey, signedMessageHash(message), true)
+		privKey.Zero()
+		a.Address = addr.String()
+		a.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	case cfg.ApprovalSig != "":
+		if cfg.ReviewerAddr == "" {
+			return fmt.Errorf("--approvalsig requires --revieweraddr")
+		}
+		a.Address = cfg.ReviewerAddr
+
+	default:
+		fmt.Println(message)
+		return nil
+	}
+
+	err = verifySignedMessage(cfg.chainParams, a.Address, a.Signature, message)
+	if err != nil {
+		return err
+	}
+	for _, prev := range m.Approvals {
+		if prev.Address == a.Address {
+			return fmt.Errorf("manifest already approved by %s", a.Address)
+		}
+	}
+	m.Approvals = append(m.Approvals, a)
+	if err := writeManifest(path, m); err != nil {
+		return err
+	}
+	log.Infof("Added approval by %s to %s", a.Address, path)
+	return nil
+}
+
+// runSignCmd runs the sign command, which signs the tspend of an approved
+// unsigned manifest after verifying the approvals of the trusted reviewers and
+// the signing policy.
+func runSignCmd(ctx context.Context, cfg *config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: sign <manifest>")
+	}
+	trusted, err := loadTrustedReviewers(reviewersPath(cfg.activeNet),
+		cfg.chainParams)
+	if err != nil {
+		return err
+	}
+	if trusted == nil {
+		return fmt.Errorf("no trusted reviewers configured in %s",
+			reviewersPath(cfg.activeNet))
+	}
+	m, msgTx, payouts, err := loadUnsignedManifest(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	if err := verifyApprovals(cfg, trusted, m); err != nil {
+		return err
+	}
+
+	var c *rpcclient.Client
+	if cfg.needsDcrd() {
+		c, err = rpcclient.New(cfg.dcrdConnConfig(), nil)
+		if err != nil {
+			return err
+		}
+		defer c.Shutdown()
+	}
+
+	// The metadata that was approved is the one checked against the
+	// policy.
+	cfg.meta = m.Metadata
+	err = enforceSigningPolicy(ctx, c, cfg, [][]*payout{payouts},
+		[]uint32{msgTx.Expiry})
+	if err != nil {
+		return err
+	}
+
+	// Refuse to sign a tspend that conflicts with one already issued for
+	// the same payouts.
+	issued, err := loadIssuedState(cfg)
+	if err != nil {
+		return err
+	}
+	setHash := payoutSetHash(payouts)
+	_, err = checkIssued(ctx, c, cfg, issued, setHash, msgTx.Expiry,
+		msgTx.TxHash().String())
+	if err != nil {
+		return err
+	}
+
+	pubKeyBytes, err := signTSpends(cfg, msgTx)
+	if err != nil {
+		return err
+	}
+	if err := issued.record(setHash, msgTx, false); err != nil {
+		return err
+	}
+	if cfg.Publish {
+		if err := checkDuplicateTSpends(ctx, c, cfg, msgTx, payouts); err != nil {
+			return err
+		}
+		_, err := c.SendRawTransaction(ctx, msgTx, true)
+		if err != nil && !isAlreadyHaveTxErr(err) {
+			return fmt.Errorf("Failed to publish tspend: %v", err)
+		}
+		log.Infof("Published TSpend to dcrd at %s", cfg.DcrdConnect)
+		if err := issued.record(setHash, msgTx, true); err != nil {
+			return err
+		}
+	}
+	if err := writeTSpends(cfg, msgTx); err != nil {
+		return err
+	}
+
+	log.Infof("TSpend Hash: %s", msgTx.TxHash())
+	log.Infof("TSpend PubKey: %x", pubKeyBytes)
+	if !isPiKey(cfg.chainParams, pubKeyBytes) {
+		log.Warnf("Private key does not correspond to a public Pi Key " +
+			"for the specified chain")
+	}
+	return nil
+}
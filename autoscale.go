@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// scalePayouts distributes the budget among the payouts proportionally to
+// their amounts, which are used as weights.
+//
+// Each payout receives the floor of its share of the budget (in atoms) and the
+// atoms left over are given one at a time to the payouts with the largest
+// remainders, breaking ties in favor of the earliest payout. The result is
+// deterministic and always adds up to exactly the budget.
+func scalePayouts(payouts []*payout, budget dcrutil.Amount) ([]*payout, error) {
+	totalWeight := new(big.Int)
+	for i, p := range payouts {
+		if p.amount <= 0 {
+			return nil, fmt.Errorf("weight of payout %d is not positive", i)
+		}
+		totalWeight.Add(totalWeight, big.NewInt(int64(p.amount)))
+	}
+
+	scaled := make([]*payout, len(payouts))
+	remainders := make([]*big.Int, len(payouts))
+	var allocated dcrutil.Amount
+	for i, p := range payouts {
+		share := new(big.Int).Mul(big.NewInt(int64(budget)),
+			big.NewInt(int64(p.amount)))
+		remainders[i] = new(big.Int)
+		share.QuoRem(share, totalWeight, remainders[i])
+		scaled[i] = &payout{
+			address: p.address,
+			amount:  dcrutil.Amount(share.Int64()),
+			name:    p.name,
+		}
+		allocated += scaled[i].amount
+	}
+
+	// Hand out the leftover atoms. There are always fewer of them than
+	// payouts.
+	order := make([]int, len(payouts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]].Cmp(remainders[order[j]]) > 0
+	})
+	for i := 0; allocated < budget; i++ {
+		scaled[order[i]].amount++
+		allocated++
+	}
+
+	for i, p := range scaled {
+		if p.amount <= 0 {
+			return nil, fmt.Errorf("budget of %s is too small to pay "+
+				"payout %d", budget, i)
+		}
+	}
+	return scaled, nil
+}
+
+// autoScalePayouts scales the payouts (whose amounts are used as weights) to
+// the amount estimated to be spendable at the end of the voting window of the
+// given expiry, after subtracting the fee of the tspend and the configured
+// safety margin.
+func autoScalePayouts(ctx context.Context, c *rpcclient.Client, cfg *config,
+	payouts []*payout, expiry uint32) ([]*payout, error) {
+
+	tvi := cfg.chainParams.TreasuryVoteInterval
+	mul := cfg.chainParams.TreasuryVoteIntervalMultiplier
+	_, voteEnd, err := blockchain.CalcTSpendWindow(expiry, tvi, mul)
+	if err != nil {
+		return nil, err
+	}
+
+	estimator, err := loadTreasuryEstimator(ctx, c, cfg.chainParams)
+	if err != nil {
+		return nil, err
+	}
+	spendable := estimator.spendableAt(int64(voteEnd))
+
+	// The size of the tspend does not depend on the amounts of its
+	// payouts, so the fee is known in advance.
+	fee, _ := tspendFee(newTSpendTx(payouts, expiry), dcrutil.Amount(cfg.FeeRate))
+	margin := dcrutil.Amount(float64(spendable) * cfg.SafetyMargin / 100)
+	budget := spendable - fee - margin
+
+	log.Infof("Estimated spendable amount at block %d: %s", voteEnd, spendable)
+	log.Infof("Fee: %s   Safety margin (%.2f%%): %s", fee, cfg.SafetyMargin,
+		margin)
+	log.Infof("Budget for payouts: %s", budget)
+	if budget <= 0 {
+		return nil, fmt.Errorf("no budget left for payouts (spendable %s, "+
+			"fee %s, margin %s)", spendable, fee, margin)
+	}
+
+	return scalePayouts(payouts, budget)
+}
+
+// confirmPayouts prints the payouts and asks for confirmation before going
+// ahead, unless confirmation was already given in the config.
+func confirmPayouts(cfg *config, payouts []*payout) error {
+	var total dcrutil.Amount
+	fmt.Fprintf(os.Stderr, "Payouts:\n")
+	for _, p := range payouts {
+		fmt.Fprintf(os.Stderr, "  %-48s %16s  %s\n",
+			payeeString(p.name, p.address), p.amount,
+			addrFingerprint(p.address))
+		total += p.amount
+	}
+	fmt.Fprintf(os.Stderr, "  %-48s %16s\n", "Total", total)
+
+	if cfg.Yes {
+		return nil
+	}
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("refusing to proceed without confirmation; " +
+			"use --yes to confirm non-interactively")
+	}
+	fmt.Fprintf(os.Stderr, "Generate tspend with these payouts? [y/N]: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("payouts not confirmed")
+}
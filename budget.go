@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+)
+
+// percentWeightScale is the scale used to turn percentages into integer
+// weights. Percentages may have at most 8 decimal places.
+const percentWeightScale = 1e8
+
+// payoutSpec is a payout as specified by the user, with either an absolute
+// amount, a percentage of the declared total or an amount in a fiat currency.
+type payoutSpec struct {
+	name     string
+	address  stdaddr.StakeAddress
+	amount   dcrutil.Amount
+	percent  *big.Rat
+	fiat     *big.Rat
+	fiatText string
+	currency string
+}
+
+// parseStakeAddress decodes an address that may receive treasury payouts.
+func parseStakeAddress(s string, chainParams *chaincfg.Params) (stdaddr.StakeAddress, error) {
+	addr, err := stdaddr.DecodeAddress(strings.TrimSpace(s), chainParams)
+	if err != nil {
+		return nil, err
+	}
+	stakeAddr, ok := addr.(stdaddr.StakeAddress)
+	if !ok {
+		return nil, fmt.Errorf("not a stakeable address (%T)", addr)
+	}
+	return stakeAddr, nil
+}
+
+// parseDCR parses an amount in DCR.
+func parseDCR(s string) (dcrutil.Amount, error) {
+	amtFloat, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return dcrutil.NewAmount(amtFloat)
+}
+
+// parseTotal parses a declared total, which is in DCR unless suffixed with
+// "atoms".
+func parseTotal(s string) (dcrutil.Amount, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	var total dcrutil.Amount
+	var err error
+	switch {
+	case strings.HasSuffix(s, "atoms"):
+		var atoms int64
+		atoms, err = strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(s, "atoms")), 10, 64)
+		total = dcrutil.Amount(atoms)
+	default:
+		total, err = parseDCR(strings.TrimSpace(strings.TrimSuffix(s, "dcr")))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid total %q: %v", s, err)
+	}
+	if total <= 0 || total > dcrutil.MaxAmount {
+		return 0, fmt.Errorf("invalid total %q: out of range", s)
+	}
+	return total, nil
+}
+
+// parseAmountSpec parses a payout amount, which is either an amount in DCR
+// (optionally suffixed with "DCR"), a percentage of the declared total
+// (suffixed with "%") or an amount in a fiat currency (suffixed with its
+// currency code, such as "1500 USD").
+func parseAmountSpec(addr stdaddr.StakeAddress, s string) (*payoutSpec, error) {
+	s = strings.TrimSpace(s)
+	spec := &payoutSpec{address: addr}
+	if fiat, text, currency := parseFiatAmount(s); fiat != nil {
+		spec.fiat, spec.fiatText, spec.currency = fiat, text, currency
+		return spec, nil
+	}
+	if strings.HasSuffix(strings.ToLower(s), "dcr") {
+		var err error
+		spec.amount, err = parseTotal(s)
+		return spec, err
+	}
+	if !strings.HasSuffix(s, "%") {
+		var err error
+		spec.amount, err = parseDCR(s)
+		return spec, err
+	}
+
+	pct, ok := new(big.Rat).SetString(strings.TrimSpace(strings.TrimSuffix(s, "%")))
+	if !ok {
+		return nil, fmt.Errorf("invalid percentage %q", s)
+	}
+	if pct.Sign() <= 0 || pct.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, fmt.Errorf("percentage %q is not in the range "+
+			"(0, 100]", s)
+	}
+	weight := new(big.Rat).Mul(pct, big.NewRat(percentWeightScale, 1))
+	if !weight.IsInt() {
+		return nil, fmt.Errorf("percentage %q has more than 8 "+
+			"decimal places", s)
+	}
+	spec.percent = pct
+	return spec, nil
+}
+
+// percentWeight returns the integer weight of a percentage.
+func percentWeight(pct *big.Rat) dcrutil.Amount {
+	weight := new(big.Rat).Mul(pct, big.NewRat(percentWeightScale, 1))
+	return dcrutil.Amount(weight.Num().Int64())
+}
+
+// resolvePayouts turns the payout specs into payouts, converting fiat amounts
+// at the configured exchange rate and resolving percentages against the
+// declared total.
+//
+// Fiat amounts are converted to atoms by dividing by the exchange rate and
+// rounding down to the nearest atom, after which they are handled as absolute
+// amounts.
+//
+// Percentages must not add up to more than 100% and must add up to exactly
+// 100% when there are no absolute amounts. The percentage payouts share
+// floor(total * sum of percentages / 100) atoms using the same rule as
+// auto-scaling: each one gets the floor of its share and the leftover atoms go
+// one at a time to the largest remainders, earliest first on ties. Absolute
+// amounts and percentage shares must not exceed the total.
+//
+// When auto-scaling, percentages are only used as weights and no total is
+// needed.
+func resolvePayouts(cfg *config, specs []*payoutSpec, declaredTotal string) ([]*payout, error) {
+	var rate *exchangeRate
+	for i, spec := range specs {
+		if spec.fiat == nil {
+			continue
+		}
+		if cfg.AutoScale {
+			return nil, fmt.Errorf("fiat amounts cannot be used " +
+				"when auto-scaling payouts")
+		}
+		if rate == nil {
+			var err error
+			if rate, err = loadExchangeRate(cfg); err != nil {
+				return nil, err
+			}
+		}
+		if spec.currency != rate.Currency {
+			return nil, fmt.Errorf("payout %d is in %s while the "+
+				"exchange rate is for %s", i, spec.currency,
+				rate.Currency)
+		}
+		var err error
+		if spec.amount, err = rate.toAtoms(spec.fiat); err != nil {
+			return nil, fmt.Errorf("payout %d: %v", i, err)
+		}
+		if spec.amount <= 0 {
+			return nil, fmt.Errorf("payout %d is worth less than "+
+				"one atom", i)
+		}
+	}
+
+	payouts := make([]*payout, len(specs))
+	var absTotal dcrutil.Amount
+	var pctPayouts []*payout
+	var pctIdx []int
+	pctSum := new(big.Rat)
+	for i, spec := range specs {
+		payouts[i] = &payout{
+			address: spec.address,
+			amount:  spec.amount,
+			name:    spec.name,
+		}
+		if spec.fiat != nil {
+			payouts[i].fiat = &fiatConversion{
+				Name:     spec.name,
+				Address:  spec.address.String(),
+				Fiat:     spec.fiatText,
+				Currency: spec.currency,
+				Exact:    rate.exactAtoms(spec.fiat).FloatString(8),
+				Rounding: fiatRounding,
+				Amount:   spec.amount,
+				Rate:     rate,
+			}
+		}
+		if spec.percent == nil {
+			absTotal += spec.amount
+			continue
+		}
+		pctSum.Add(pctSum, spec.percent)
+		pctIdx = append(pctIdx, i)
+		pctPayouts = append(pctPayouts, &payout{
+			address: spec.address,
+			amount:  percentWeight(spec.percent),
+			name:    spec.name,
+		})
+	}
+
+	if cfg.Total != "" {
+		declaredTotal = cfg.Total
+	}
+	if cfg.AutoScale {
+		if declaredTotal != "" {
+			return nil, fmt.Errorf("a total cannot be declared " +
+				"when auto-scaling payouts")
+		}
+		if len(pctIdx) > 0 && len(pctIdx) != len(specs) {
+			return nil, fmt.Errorf("percentages and absolute " +
+				"amounts cannot be mixed when auto-scaling payouts")
+		}
+		for j, i := range pctIdx {
+			payouts[i] = pctPayouts[j]
+		}
+		return payouts, nil
+	}
+
+	if declaredTotal == "" {
+		if len(pctIdx) > 0 {
+			return nil, fmt.Errorf("percentage payouts require a " +
+				"declared total (--total)")
+		}
+		return payouts, nil
+	}
+	total, err := parseTotal(declaredTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	hundred := big.NewRat(100, 1)
+	switch {
+	case pctSum.Cmp(hundred) > 0:
+		return nil, fmt.Errorf("percentages add up to %s%%, which is "+
+			"more than 100%%", pctSum.FloatString(8))
+	case len(pctIdx) > 0 && len(pctIdx) == len(specs) && pctSum.Cmp(hundred) != 0:
+		return nil, fmt.Errorf("percentages add up to %s%% instead "+
+			"of 100%%", pctSum.FloatString(8))
+	}
+
+	if len(pctIdx) > 0 {
+		pool := new(big.Rat).Mul(big.NewRat(int64(total), 1), pctSum)
+		pool.Quo(pool, hundred)
+		poolAtoms := dcrutil.Amount(new(big.Int).Quo(pool.Num(), pool.Denom()).Int64())
+		shares, err := scalePayouts(pctPayouts, poolAtoms)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range pctIdx {
+			payouts[i] = shares[j]
+		}
+		absTotal += poolAtoms
+	}
+	switch {
+	case absTotal > total:
+		return nil, fmt.Errorf("payouts add up to %s, which is more "+
+			"than the declared total of %s", absTotal, total)
+	case absTotal < total:
+		return nil, fmt.Errorf("payouts add up to %s, leaving %s of "+
+			"the declared total of %s unallocated", absTotal,
+			total-absTotal, total)
+	}
+	return payouts, nil
+}
+
+// jsonPayouts is the format of a JSON payouts file.
+type jsonPayouts struct {
+	Total   string `json:"total"`
+	Payouts []struct {
+		Address string `json:"address"`
+		Amount  string `json:"amount"`
+	} `json:"payouts"`
+}
+
+// payoutsFromJSON loads the payouts from a JSON file in the form:
+//
+//	{"total": "1000", "payouts": [{"address": "Ds...", "amount": "30%"}]}
+//
+// The address of a payout may also be the name of a payee in the address book.
+func payoutsFromJSON(cfg *config) ([]*payout, error) {
+	b, err := os.ReadFile(cfg.JSON)
+	if err != nil {
+		return nil, err
+	}
+	var in jsonPayouts
+	if err := json.Unmarshal(b, &in); err != nil {
+		return nil, fmt.Errorf("unable to decode payouts file: %v", err)
+	}
+
+	specs := make([]*payoutSpec, 0, len(in.Payouts))
+	for i, p := range in.Payouts {
+		name, addr, err := resolvePayee(cfg, p.Address)
+		if err != nil {
+			return nil, fmt.Errorf("payout %d address: %v", i, err)
+		}
+		spec, err := parseAmountSpec(addr, p.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("payout %d amount: %v", i, err)
+		}
+		spec.name = name
+		specs = append(specs, spec)
+	}
+	return resolvePayouts(cfg, specs, in.Total)
+}
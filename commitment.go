@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/crypto/blake256"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/wire"
+)
+
+const (
+	// commitmentMagic marks an OP_RETURN payload that commits to a payout
+	// manifest.
+	commitmentMagic = "TSC"
+
+	// commitmentVersion is the version of the manifest commitment payload.
+	commitmentVersion = 1
+
+	// commitmentHashSize is the number of bytes of the manifest hash in the
+	// commitment payload.
+	commitmentHashSize = 20
+)
+
+// paymentManifest is an off-chain manifest of the payouts of a tspend, linking
+// them to the proposals and invoices they pay. Only the payouts are
+// interpreted; tspends commit to the exact bytes of the manifest file.
+type paymentManifest struct {
+	Payouts []struct {
+		Address  string `json:"address"`
+		Amount   string `json:"amount"`
+		Proposal string `json:"proposal"`
+		Invoice  string `json:"invoice"`
+	} `json:"payouts"`
+}
+
+// manifestCommitment returns the 24 bytes of extra OP_RETURN data that commit
+// to a payout manifest:
+//
+//	"TSC" || version (1 byte) || first 20 bytes of blake256(manifest)
+func manifestCommitment(manifest []byte) []byte {
+	hash := blake256.Sum256(manifest)
+	commitment := make([]byte, 0, opReturnExtraSize)
+	commitment = append(commitment, commitmentMagic...)
+	commitment = append(commitment, commitmentVersion)
+	return append(commitment, hash[:commitmentHashSize]...)
+}
+
+// checkManifestPayouts ensures the payouts listed in a manifest are the given
+// payouts, grouped by address. Every mismatching address is logged.
+func checkManifestPayouts(cfg *config, manifest *paymentManifest, payouts []*payout) error {
+	want := make(map[string]dcrutil.Amount)
+	for i, p := range manifest.Payouts {
+		addr, err := parseStakeAddress(p.Address, cfg.chainParams)
+		if err != nil {
+			return fmt.Errorf("manifest payout %d: %v", i, err)
+		}
+		amount, err := parseDCR(p.Amount)
+		if err != nil {
+			return fmt.Errorf("manifest payout %d: %v", i, err)
+		}
+		want[addr.String()] += amount
+	}
+	got := make(map[string]dcrutil.Amount)
+	for _, p := range payouts {
+		got[p.address.String()] += p.amount
+	}
+	addrs := make([]string, 0, len(want)+len(got))
+	for addr := range want {
+		addrs = append(addrs, addr)
+	}
+	for addr := range got {
+		if _, ok := want[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	var mismatches int
+	for _, addr := range addrs {
+		if want[addr] != got[addr] {
+			log.Errorf("Address %s: manifest pays %s, tspend pays %s",
+				addr, want[addr], got[addr])
+			mismatches++
+		}
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("the amounts paid to %d addresses differ",
+			mismatches)
+	}
+	return nil
+}
+
+// loadManifestCommitment reads a payout manifest and returns the extra
+// OP_RETURN data that commits to it, after ensuring it lists exactly the
+// given payouts.
+func loadManifestCommitment(cfg *config, path string, payouts []*payout) ([]byte, error) {
+	manifestBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest paymentManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, fmt.Errorf("unable to decode manifest: %v", err)
+	}
+	if err := checkManifestPayouts(cfg, &manifest, payouts); err != nil {
+		return nil, fmt.Errorf("payouts do not match the ones of "+
+			"manifest %s: %v", path, err)
+	}
+	return manifestCommitment(manifestBytes), nil
+}
+
+// runCommitmentCmd runs the commitment command, which prints the extra
+// OP_RETURN data that commits to the given payout manifest.
+func runCommitmentCmd(cfg *config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: commitment <manifest>")
+	}
+	manifest, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%x\n", manifestCommitment(manifest))
+	return nil
+}
+
+// loadCommittedTSpend loads the tspend to verify, given either by its hash
+// (fetched from dcrd) or by a file (or "-" for stdin) with its hex encoding.
+func loadCommittedTSpend(ctx context.Context, c *rpcclient.Client, arg string) (*wire.MsgTx, int64, error) {
+	if !txHashRE.MatchString(arg) {
+		msgTxs, err := readTSpends(arg)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(msgTxs) != 1 {
+			return nil, 0, fmt.Errorf("expected a single tspend in %s, "+
+				"found %d", arg, len(msgTxs))
+		}
+		return msgTxs[0], 0, nil
+	}
+
+	hash, err := chainhash.NewHashFromStr(arg)
+	if err != nil {
+		return nil, 0, err
+	}
+	tx, err := c.GetRawTransactionVerbose(ctx, hash)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to fetch tspend %s: %v", hash, err)
+	}
+	rawTx, err := hex.DecodeString(tx.Hex)
+	if err != nil {
+		return nil, 0, err
+	}
+	var msgTx wire.MsgTx
+	if err := msgTx.FromBytes(rawTx); err != nil {
+		return nil, 0, err
+	}
+	return &msgTx, tx.BlockHeight, nil
+}
+
+// runVerifyCommitmentCmd runs the verifycommitment command, which verifies
+// that the OP_RETURN of a tspend commits to the given payout manifest and that
+// the tspend pays exactly the payouts listed in it.
+func runVerifyCommitmentCmd(ctx context.Context, cfg *config, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: verifycommitment <manifest> " +
+			"<txhash|file|->")
+	}
+	manifestBytes, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var manifest paymentManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return fmt.Errorf("unable to decode manifest: %v", err)
+	}
+
+	var c *rpcclient.Client
+	if cfg.needsDcrd() {
+		c, err = rpcclient.New(cfg.dcrdConnConfig(), nil)
+		if err != nil {
+			return err
+		}
+		defer c.Shutdown()
+	}
+	msgTx, blockHeight, err := loadCommittedTSpend(ctx, c, args[1])
+	if err != nil {
+		return err
+	}
+	txPayouts, err := tspendPayouts(msgTx, cfg.chainParams)
+	if err != nil {
+		return err
+	}
+
+	// The OP_RETURN must commit to the manifest.
+	payload, err := opReturnPayload(msgTx)
+	if err != nil {
+		return err
+	}
+	commitment := payload[8:]
+	if string(commitment[:len(commitmentMagic)]) != commitmentMagic {
+		return fmt.Errorf("OP_RETURN of tspend %s does not commit to a "+
+			"manifest", msgTx.TxHash())
+	}
+	if v := commitment[len(commitmentMagic)]; v != commitmentVersion {
+		return fmt.Errorf("unsupported manifest commitment version %d", v)
+	}
+	if !bytes.Equal(commitment, manifestCommitment(manifestBytes)) {
+		return fmt.Errorf("OP_RETURN of tspend %s does not commit to "+
+			"manifest %s", msgTx.TxHash(), args[0])
+	}
+
+	// The payouts listed in the manifest must be the ones of the tspend.
+	if err := checkManifestPayouts(cfg, &manifest, txPayouts); err != nil {
+		return fmt.Errorf("tspend %s does not pay the payouts of "+
+			"manifest %s: %v", msgTx.TxHash(), args[0], err)
+	}
+
+	fmt.Printf("TSpend %s commits to manifest %s (version %d, hash %x)\n",
+		msgTx.TxHash(), args[0], commitmentVersion,
+		commitment[len(commitmentMagic)+1:])
+	if blockHeight > 0 {
+		fmt.Printf("Mined in block %d\n", blockHeight)
+	}
+	fmt.Printf("Value in: %s\n", dcrutil.Amount(binary.LittleEndian.Uint64(payload)))
+	for _, p := range manifest.Payouts {
+		fmt.Printf("  %-36s %16s  proposal %s  invoice %s\n", p.Address,
+			p.Amount, p.Proposal, p.Invoice)
+	}
+	return nil
+}
@@ -77,6 +77,14 @@ var (
 	errCmdDone = errors.New("cmd is done while parsing config options")
 )
 
+// usage is the usage message of the app, listing its commands.
+const usage = "[OPTIONS] [generate | addrbook [list | add <name> <address> | " +
+	"update <name> <address> | remove <name>] | fingerprint <address>... | " +
+	"inspect <file|-> | approve <manifest> | sign <manifest> | " +
+	"reissue <txhash|file|-> | commitment <manifest> | " +
+	"verifycommitment <manifest> <txhash|file|-> | " +
+	"opreturn [vectors <file> | verify <file|-> [<extra data>]]]"
+
 type config struct {
 	ShowVersion bool `short:"V" long:"version" description:"Display version information and exit"`
 
@@ -101,25 +109,74 @@ type config struct {
 
 	// TSpend data
 
-	FeeRate       int64     `long:"feerate" description:"Fee rate for the tspend in atoms/kB"`
-	PrivKey       string    `long:"privkey" description:"Private key to use to sign tspend"`
-	PrivKeyFile   string    `long:"privkeyfile" description:"Private key file to use to sign tspend"`
-	OpReturnData  string    `long:"opreturndata" description:"OP_RETURN payload data. Random data if unspencified"`
-	Publish       bool      `long:"publish" description:"Directly publish the tspend"`
-	Expiry        int       `long:"expiry" description:"Expiry to use"`
-	CurrentHeight int       `short:"c" long:"currentheight" description:"Current blockchain height to calculate a sane expiry from"`
-	Addresses     []string  `long:"address" description:"List of addresses to send to. Number of addresses must match amounts"`
-	Amounts       []float64 `long:"amount" description:"List of amounts to send in DCR. Number of amounts must match addresses"`
-	CSV           string    `long:"csv" description:"Generate the tspend based on a csv file"`
-	Out           string    `long:"out" description:"Write resulting hex tspend to the specified file"`
-	Spew          bool      `long:"spew" description:"Spew the result tspend"`
+	FeeRate       int64    `long:"feerate" description:"Fee rate for the tspend in atoms/kB"`
+	PrivKey       string   `long:"privkey" description:"Private key to use to sign tspend"`
+	PrivKeyFile   string   `long:"privkeyfile" description:"Private key file to use to sign tspend"`
+	OpReturnData  string   `long:"opreturndata" description:"Hex OP_RETURN extra data: at most 24 bytes, or 32 bytes hashed with --deterministic. Random data if unspecified"`
+	Publish       bool     `long:"publish" description:"Directly publish the tspend"`
+	Expiry        int      `long:"expiry" description:"Expiry to use"`
+	CurrentHeight int      `short:"c" long:"currentheight" description:"Current blockchain height to calculate a sane expiry from"`
+	Addresses     []string `long:"address" description:"List of addresses to send to. Number of addresses must match amounts"`
+	Amounts       []string `long:"amount" description:"List of amounts to send in DCR or as a percentage of the total (e.g. 30%). Number of amounts must match addresses"`
+	Total         string   `long:"total" description:"Declared total the percentage amounts refer to, in DCR or atoms (e.g. 1000 or 100000000000atoms)"`
+	CSV           string   `long:"csv" description:"Generate the tspend based on a csv file"`
+	JSON          string   `long:"json" description:"Generate the tspend based on a json file"`
+	Out           string   `long:"out" description:"Write resulting tspend to the specified file"`
+	Formats       []string `long:"format" description:"Output format of the tspend: hex (default), binary, base64 or json, optionally followed by :<file> to write it to that file instead of --out or stdout. May be repeated to write several formats"`
+	Spew          bool     `long:"spew" description:"Spew the result tspend"`
 
 	DeterministicOpReturn bool `long:"deterministic" description:"Use a deterministic OP_RETURN data based on the input payloads"`
 
+	CommitManifest string `long:"commitmanifest" description:"Commit the OP_RETURN data to the given off-chain payout manifest (proposal tokens, invoice IDs)"`
+
+	AllowSimilar bool `long:"allowsimilar" description:"Publish the tspend even if a tspend with similar payouts is in the mempool or was recently mined"`
+
+	Reissue bool `long:"reissue" description:"Generate a new tspend even if one was already issued for the same payouts"`
+
+	Meta []string `long:"meta" description:"Metadata field (key=value) recorded with the tspend, as required by the signing policy"`
+
+	// Fiat conversion
+
+	Rate         string `long:"rate" description:"Price of one DCR in the fiat currency of the payouts"`
+	RateCurrency string `long:"ratecurrency" description:"Fiat currency of the exchange rate"`
+	RateSource   string `long:"ratesource" description:"Source of the exchange rate (e.g. the exchange or index it was taken from)"`
+	RateTime     string `long:"ratetime" description:"Timestamp of the exchange rate in RFC3339 format"`
+	RateFile     string `long:"ratefile" description:"JSON file with the exchange rate ({currency, rate, source, timestamp})"`
+
+	// Auto-scaling
+
+	AutoScale    bool    `long:"autoscale" description:"Treat payout amounts as weights (or percentages) and scale them to the amount estimated to be spendable at the end of the voting window"`
+	SafetyMargin float64 `long:"safetymargin" description:"Percentage of the spendable estimate left unspent when auto-scaling payouts"`
+	Yes          bool    `long:"yes" description:"Do not ask for confirmation of the auto-scaled payouts"`
+
+	// Vesting schedule
+
+	Installments int    `long:"installments" description:"Split the payouts across this number of tspends in successive voting windows"`
+	WindowStep   int    `long:"windowstep" description:"Number of voting windows between installments"`
+	Manifest     string `long:"manifest" description:"Write the vesting schedule manifest to the specified file"`
+
+	// Reviewer approvals
+
+	Unsigned     string `long:"unsigned" description:"Write the unsigned manifest of the tspend to the specified file for review instead of signing it"`
+	ReviewerKey  string `long:"reviewerkey" description:"WIF private key of the reviewer used by the approve command"`
+	ReviewerAddr string `long:"revieweraddr" description:"Address of the reviewer that produced --approvalsig"`
+	ApprovalSig  string `long:"approvalsig" description:"Base64 signature of the approval message produced elsewhere (e.g. with the signmessage command of a wallet)"`
+	Reviewer     string `long:"reviewer" description:"Name of the reviewer recorded with the approval"`
+
+	// Address book
+
+	AddrBook string `long:"addrbook" description:"Address book file mapping payee names to addresses (default: <network>-addrbook.json in the app data dir)"`
+
 	// The rest of the members of this struct are filled by loadConfig().
 
 	activeNet   chainNetwork
 	chainParams *chaincfg.Params
+	command     string
+	args        []string
+	addrBook    *addrBook
+	outputs     []outputSpec
+	policy      *signingPolicy
+	meta        map[string]string
 }
 
 func (c *config) dcrdConnConfig() *rpcclient.ConnConfig {
@@ -135,8 +192,36 @@ func (c *config) dcrdConnConfig() *rpcclient.ConnConfig {
 // needsDcrd returns true if the config means the app will need to connect to
 // the dcrd instance.
 func (c *config) needsDcrd() bool {
-	needsBestHeight := c.Expiry == 0 && c.CurrentHeight == 0
-	return needsBestHeight || c.Publish
+	// The lead time of the signing policy is always checked against the
+	// tip reported by dcrd.
+	needsLeadTime := c.policy != nil && c.policy.MinLeadBlocks > 0
+	switch c.command {
+	case "generate":
+		needsBestHeight := c.Expiry == 0 && c.CurrentHeight == 0
+		return needsBestHeight || needsLeadTime || c.Publish ||
+			c.AutoScale || c.Installments > 1
+	case "sign":
+		return needsLeadTime || c.Publish
+	case "reissue":
+		// The blocks of the voting window of the original tspend are
+		// searched to verify that it was not mined.
+		return true
+	case "verifycommitment":
+		// Tspends given by hash are fetched from dcrd.
+		return len(c.args) == 2 && txHashRE.MatchString(c.args[1])
+	}
+	return false
+}
+
+// needsPrivKey returns true if the command signs tspends with the private key.
+func (c *config) needsPrivKey() bool {
+	switch c.command {
+	case "generate", "reissue":
+		return c.Unsigned == ""
+	case "sign":
+		return true
+	}
+	return false
 }
 
 func (c *config) privKeyFromStdin() bool {
@@ -244,6 +329,9 @@ func loadConfig() (*config, []string, error) {
 		DcrdCertPath: defaultDcrdCertPath,
 		DebugLevel:   defaultLogLevel,
 		FeeRate:      int64(DefaultRelayFeePerKb),
+		SafetyMargin: 1,
+		WindowStep:   1,
+		RateCurrency: "USD",
 	}
 
 	// Pre-parse the command line options to see if an alternative config
@@ -252,6 +340,7 @@ func loadConfig() (*config, []string, error) {
 	// the final parse below.
 	preCfg := cfg
 	preParser := flags.NewParser(&preCfg, flags.HelpFlag)
+	preParser.Usage = usage
 	_, err := preParser.Parse()
 	if err != nil {
 		if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
@@ -286,6 +375,7 @@ func loadConfig() (*config, []string, error) {
 	// Load additional config from file.
 	var configFileError error
 	parser := flags.NewParser(&cfg, flags.Default)
+	parser.Usage = usage
 
 	err = flags.NewIniParser(parser).ParseFile(preCfg.ConfigFile)
 	if err != nil {
@@ -332,12 +422,86 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Determine the command to run. Without one, a tspend is generated.
+	cfg.command = "generate"
+	if len(remainingArgs) > 0 {
+		cfg.command, cfg.args = remainingArgs[0], remainingArgs[1:]
+	}
+	switch cfg.command {
+	case "generate", "addrbook", "fingerprint", "inspect", "approve", "sign",
+		"reissue", "commitment", "verifycommitment", "opreturn":
+	default:
+		return nil, nil, fmt.Errorf("unknown command %q", cfg.command)
+	}
+	if cfg.AddrBook == "" {
+		cfg.AddrBook = filepath.Join(defaultConfigDir,
+			string(cfg.activeNet)+"-addrbook.json")
+	}
+
+	// Load the signing policy of the network and the metadata it may
+	// require.
+	switch cfg.command {
+	case "generate", "sign", "reissue":
+		cfg.policy, err = loadSigningPolicy(policyPath(cfg.activeNet),
+			cfg.chainParams)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cfg.meta, err = parseMeta(cfg.Meta); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Number of addresses and amounts must match.
 	if len(cfg.Addresses) != len(cfg.Amounts) {
 		return nil, nil, fmt.Errorf("Number of addresses (%d) must match "+
 			"number of amounts (%d)", len(cfg.Addresses), len(cfg.Amounts))
 	}
 
+	if cfg.SafetyMargin < 0 || cfg.SafetyMargin >= 100 {
+		return nil, nil, fmt.Errorf("safety margin must be a percentage " +
+			"between 0 and 100")
+	}
+
+	if cfg.RateFile != "" && cfg.Rate != "" {
+		return nil, nil, fmt.Errorf("--rate and --ratefile cannot be " +
+			"used together")
+	}
+
+	if cfg.CommitManifest != "" && (cfg.DeterministicOpReturn || cfg.OpReturnData != "") {
+		return nil, nil, fmt.Errorf("--commitmanifest cannot be used " +
+			"with --deterministic or --opreturndata")
+	}
+
+	cfg.outputs, err = parseOutputSpecs(cfg.Formats, cfg.Out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.Unsigned != "" && cfg.Publish {
+		return nil, nil, fmt.Errorf("--unsigned cannot be used with " +
+			"--publish")
+	}
+
+	if cfg.Installments > 1 {
+		switch {
+		case cfg.WindowStep < 1:
+			return nil, nil, fmt.Errorf("window step must be at least 1")
+		case cfg.Manifest == "":
+			return nil, nil, fmt.Errorf("a vesting schedule requires " +
+				"--manifest")
+		case cfg.Publish:
+			return nil, nil, fmt.Errorf("installments of a vesting " +
+				"schedule cannot be published at once")
+		case cfg.AutoScale:
+			return nil, nil, fmt.Errorf("--autoscale cannot be used " +
+				"with a vesting schedule")
+		case cfg.Unsigned != "":
+			return nil, nil, fmt.Errorf("--unsigned cannot be used " +
+				"with a vesting schedule")
+		}
+	}
+
 	// Initialize log rotation.  After log rotation has been initialized,
 	// the logger variables may be used.
 	logDir := strings.Replace(defaultLogDir, string(defaultActiveNet),
@@ -386,13 +550,16 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
-	// Fill in the default PrivKeyFile if both it and PrivKey are empty.
-	if cfg.PrivKeyFile == "" && cfg.PrivKey == "" {
-		cfg.PrivKeyFile = filepath.Join(defaultConfigDir, string(cfg.activeNet)+".key")
-	}
-	if cfg.PrivKeyFile != "" {
-		if _, err := os.Stat(cfg.PrivKeyFile); err != nil {
-			return nil, nil, fmt.Errorf("PrivKeyFile error: %v", err)
+	// Fill in the default PrivKeyFile if both it and PrivKey are empty. The
+	// key is only needed to sign tspends.
+	if cfg.needsPrivKey() {
+		if cfg.PrivKeyFile == "" && cfg.PrivKey == "" {
+			cfg.PrivKeyFile = filepath.Join(defaultConfigDir, string(cfg.activeNet)+".key")
+		}
+		if cfg.PrivKeyFile != "" {
+			if _, err := os.Stat(cfg.PrivKeyFile); err != nil {
+				return nil, nil, fmt.Errorf("PrivKeyFile error: %v", err)
+			}
 		}
 	}
 
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/wire"
+)
+
+// tspendMatch is an existing tspend that pays the same (or almost the same)
+// payouts as a new one.
+type tspendMatch struct {
+	txHash string
+	where  string
+	exact  bool
+}
+
+// payoutPairs returns the number of times each address/amount pair is paid by
+// the payouts.
+func payoutPairs(payouts []*payout) map[string]int {
+	pairs := make(map[string]int, len(payouts))
+	for _, p := range payouts {
+		pairs[fmt.Sprintf("%s:%d", p.address, int64(p.amount))]++
+	}
+	return pairs
+}
+
+// comparePayouts compares the payouts of a new tspend to the ones of an
+// existing tspend. They are an exact duplicate when they pay the same
+// address/amount pairs (regardless of order) and a near duplicate when at
+// least half of the address/amount pairs of the new tspend are also paid by
+// the existing one. Paying the same addresses with different amounts is not a
+// near duplicate.
+func comparePayouts(payouts, other []*payout) (exact, near bool) {
+	if payoutSetHash(payouts) == payoutSetHash(other) {
+		return true, false
+	}
+
+	otherPairs := payoutPairs(other)
+	var matched int
+	for pair, n := range payoutPairs(payouts) {
+		if m := otherPairs[pair]; m < n {
+			matched += m
+		} else {
+			matched += n
+		}
+	}
+	return false, matched*2 >= len(payouts)
+}
+
+// minedTSpends returns the tspends mined in the policy window ending at the
+// current tip. Only the blocks whose treasury updates include spends are
+// fetched.
+func minedTSpends(ctx context.Context, c *rpcclient.Client, cfg *config) (map[int64][]*wire.MsgTx, error) {
+	_, tipHeight, err := c.GetBestBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	params := cfg.chainParams
+	policyWindow := int64(params.TreasuryVoteInterval *
+		params.TreasuryVoteIntervalMultiplier *
+		params.TreasuryExpenditureWindow)
+	startHeight := tipHeight - policyWindow + 1
+	if startHeight < 1 {
+		startHeight = 1
+	}
+	updates, err := fetchTreasuryUpdates(ctx, c, startHeight, tipHeight)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch treasury updates: %v", err)
+	}
+
+	mined := make(map[int64][]*wire.MsgTx)
+	for i, blockUpdates := range updates {
+		var hasSpends bool
+		for _, v := range blockUpdates {
+			hasSpends = hasSpends || v < 0
+		}
+		if !hasSpends {
+			continue
+		}
+		height := startHeight + int64(i)
+		hash, err := c.GetBlockHash(ctx, height)
+		if err != nil {
+			return nil, err
+		}
+		block, err := c.GetBlock(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, stx := range block.STransactions {
+			if stake.IsTSpend(stx) {
+				mined[height] = append(mined[height], stx)
+			}
+		}
+	}
+	return mined, nil
+}
+
+// findDuplicateTSpends returns the tspends in the mempool of dcrd or mined in
+// the last policy window that pay the same (or almost the same) payouts as
+// the given tspend.
+func findDuplicateTSpends(ctx context.Context, c *rpcclient.Client, cfg *config,
+	msgTx *wire.MsgTx, payouts []*payout) ([]tspendMatch, error) {
+
+	txHash := msgTx.TxHash()
+	var matches []tspendMatch
+	check := func(other *wire.MsgTx, where string) {
+		if other.TxHash() == txHash {
+			return
+		}
+		otherPayouts, err := tspendPayouts(other, cfg.chainParams)
+		if err != nil {
+			log.Debugf("Unable to decode payouts of tspend %s: %v",
+				other.TxHash(), err)
+			return
+		}
+		exact, near := comparePayouts(payouts, otherPayouts)
+		if exact || near {
+			matches = append(matches, tspendMatch{
+				txHash: other.TxHash().String(),
+				where:  where,
+				exact:  exact,
+			})
+		}
+	}
+
+	mempool, err := mempoolTSpends(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range mempool {
+		check(other, "in the mempool")
+	}
+
+	mined, err := minedTSpends(ctx, c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	heights := make([]int64, 0, len(mined))
+	for height := range mined {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	for _, height := range heights {
+		for _, other := range mined[height] {
+			check(other, fmt.Sprintf("mined in block %d", height))
+		}
+	}
+	return matches, nil
+}
+
+// checkDuplicateTSpends refuses to publish a tspend that duplicates the
+// payouts of a tspend already in the mempool or recently mined. Near
+// duplicates are also refused unless explicitly allowed.
+func checkDuplicateTSpends(ctx context.Context, c *rpcclient.Client, cfg *config,
+	msgTx *wire.MsgTx, payouts []*payout) error {
+
+	matches, err := findDuplicateTSpends(ctx, c, cfg, msgTx, payouts)
+	if err != nil {
+		return fmt.Errorf("unable to check for duplicate tspends: %v", err)
+	}
+	var exact, near int
+	for _, m := range matches {
+		if m.exact {
+			log.Errorf("TSpend %s (%s) pays exactly the same payouts",
+				m.txHash, m.where)
+			exact++
+		} else {
+			log.Warnf("TSpend %s (%s) pays similar payouts", m.txHash,
+				m.where)
+			near++
+		}
+	}
+	switch {
+	case exact > 0:
+		return fmt.Errorf("refusing to publish a duplicate of an " +
+			"existing tspend")
+	case near > 0 && !cfg.AllowSimilar:
+		return fmt.Errorf("refusing to publish a tspend similar to an " +
+			"existing one; use --allowsimilar to publish it anyway")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// TestComparePayouts ensures exact and near duplicates are detected from the
+// address/amount pairs paid and not from the set of addresses alone.
+func TestComparePayouts(t *testing.T) {
+	params := chaincfg.SimNetParams()
+	addrs := []string{
+		"SsWKp7wtdTZYabYFYSc9cnxhwFEjA5g4pFc",
+		"SsnhVyWxY6c5xEztSBb9xBqf9gdjEHpyCDx",
+	}
+	payouts := func(amounts ...int64) []*payout {
+		var ps []*payout
+		for i, amount := range amounts {
+			addr, err := parseStakeAddress(addrs[i%len(addrs)], params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ps = append(ps, &payout{
+				address: addr,
+				amount:  dcrutil.Amount(amount),
+			})
+		}
+		return ps
+	}
+
+	tests := []struct {
+		name        string
+		new, other  []*payout
+		exact, near bool
+	}{{
+		name:  "same pairs",
+		new:   payouts(100, 200),
+		other: payouts(100, 200),
+		exact: true,
+	}, {
+		name:  "same addresses, different amounts",
+		new:   payouts(100, 200),
+		other: payouts(101, 201),
+	}, {
+		name:  "same address, different amount",
+		new:   payouts(100),
+		other: payouts(200),
+	}, {
+		name:  "half of the pairs",
+		new:   payouts(100, 200),
+		other: payouts(100, 201),
+		near:  true,
+	}, {
+		name:  "less than half of the pairs",
+		new:   payouts(100, 200, 300),
+		other: payouts(100, 201, 301),
+	}, {
+		name:  "superset of the pairs",
+		new:   payouts(100, 200),
+		other: payouts(100, 200, 300),
+		near:  true,
+	}}
+	for _, test := range tests {
+		exact, near := comparePayouts(test.new, test.other)
+		if exact != test.exact || near != test.near {
+			t.Fatalf("%s: got exact %v near %v, want exact %v "+
+				"near %v", test.name, exact, near, test.exact,
+				test.near)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/wire"
+	"github.com/matheusd/tspend/internal/treasury"
+)
+
+// estimateWorkers is the number of concurrent requests made to dcrd while
+// fetching the treasury changes of past blocks.
+const estimateWorkers = 8
+
+// fetchTreasuryUpdates fetches the treasury updates of every block in the
+// range [startHeight, endHeight] from dcrd.
+func fetchTreasuryUpdates(ctx context.Context, c *rpcclient.Client,
+	startHeight, endHeight int64) ([][]int64, error) {
+
+	if endHeight < startHeight {
+		return nil, nil
+	}
+	updates := make([][]int64, endHeight-startHeight+1)
+	heights := make([]uint32, 0, len(updates))
+	for h := startHeight; h <= endHeight; h++ {
+		heights = append(heights, uint32(h))
+	}
+	err := treasury.RunChunked(ctx, heights, estimateWorkers, func(h uint32) error {
+		hash, err := c.GetBlockHash(ctx, int64(h))
+		if err != nil {
+			return err
+		}
+		tbalance, err := c.GetTreasuryBalance(ctx, hash, true)
+		if err != nil {
+			return err
+		}
+		updates[int64(h)-startHeight] = tbalance.Updates
+		return nil
+	})
+	return updates, err
+}
+
+// mempoolTSpends returns the tspends currently in the mempool of the dcrd
+// instance.
+func mempoolTSpends(ctx context.Context, c *rpcclient.Client) ([]*wire.MsgTx, error) {
+	votes, err := c.GetTreasurySpendVotes(ctx, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch mempool tspends: %v", err)
+	}
+	msgTxs := make([]*wire.MsgTx, 0, len(votes.Votes))
+	for _, v := range votes.Votes {
+		hash, err := chainhash.NewHashFromStr(v.Hash)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := c.GetRawTransaction(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch mempool tspend "+
+				"%s: %v", hash, err)
+		}
+		if !stake.IsTSpend(tx.MsgTx()) {
+			return nil, fmt.Errorf("mempool tx %s is not a tspend", hash)
+		}
+		msgTxs = append(msgTxs, tx.MsgTx())
+	}
+	return msgTxs, nil
+}
+
+// mempoolTSpendsTotal returns the total amount of the tspends currently in the
+// mempool of the dcrd instance.
+func mempoolTSpendsTotal(ctx context.Context, c *rpcclient.Client) (dcrutil.Amount, error) {
+	msgTxs, err := mempoolTSpends(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	var total dcrutil.Amount
+	for _, msgTx := range msgTxs {
+		total += dcrutil.Amount(msgTx.TxIn[0].ValueIn)
+	}
+	return total, nil
+}
+
+// treasuryEstimator estimates the amounts that may be spent by tspends
+// included at future heights, under the expenditure policy in effect at each
+// height.
+type treasuryEstimator struct {
+	policy    *treasury.Policy
+	tipHeight int64
+
+	// tadds and spent are the treasury adds and tspends (including fees)
+	// mined in each block from firstHeight to the tip. This covers the
+	// policy window ending at the tip and, while the DCP0006 policy is in
+	// effect, the policy windows preceding it.
+	firstHeight int64
+	tadds       []dcrutil.Amount
+	spent       []dcrutil.Amount
+
+	// mempool is the total amount of the tspends that may still be mined.
+	mempool dcrutil.Amount
+}
+
+// loadTreasuryEstimator fetches the treasury changes that may count against
+// the policy window of a future block (or, under DCP0006, against the policy
+// windows preceding it).
+func loadTreasuryEstimator(ctx context.Context, c *rpcclient.Client,
+	chainParams *chaincfg.Params) (*treasuryEstimator, error) {
+
+	binfo, err := c.GetBlockChainInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := treasury.LoadConsensusRules(binfo, chainParams)
+	if rules.At(binfo.Blocks) == treasury.RulesPreTreasury {
+		return nil, fmt.Errorf("treasury agenda is not active")
+	}
+
+	e := &treasuryEstimator{
+		policy:    treasury.NewPolicy(chainParams, rules),
+		tipHeight: binfo.Blocks,
+	}
+	nbWindows := int64(1)
+	if rules.At(e.tipHeight) != treasury.RulesDCP0007 {
+		nbWindows += int64(chainParams.TreasuryExpenditurePolicy)
+	}
+	e.firstHeight = e.tipHeight - e.policy.Window*nbWindows + 1
+	if e.firstHeight < rules.TreasuryHeight {
+		e.firstHeight = rules.TreasuryHeight
+	}
+
+	// Updates follow the order of the stake transactions, so the first
+	// one is the treasurybase.
+	updates, err := fetchTreasuryUpdates(ctx, c, e.firstHeight, e.tipHeight)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch treasury updates: %v", err)
+	}
+	e.tadds = make([]dcrutil.Amount, len(updates))
+	e.spent = make([]dcrutil.Amount, len(updates))
+	for i, blockUpdates := range updates {
+		for j, v := range blockUpdates {
+			switch {
+			case v > 0 && j > 0:
+				e.tadds[i] += dcrutil.Amount(v)
+			case v < 0:
+				e.spent[i] += dcrutil.Amount(-v)
+			}
+		}
+	}
+
+	e.mempool, err = mempoolTSpendsTotal(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// known returns the sum of the mined amounts in the policy window ending at
+// the given height.
+func (e *treasuryEstimator) known(amounts []dcrutil.Amount, height int64) dcrutil.Amount {
+	var sum dcrutil.Amount
+	for h := height - e.policy.Window + 1; h <= height; h++ {
+		if h >= e.firstHeight && h <= e.tipHeight {
+			sum += amounts[h-e.firstHeight]
+		}
+	}
+	return sum
+}
+
+// policyLimitAt estimates the maximum expenditure of the policy window ending
+// at the given height. Treasury bases of future blocks are calculated from the
+// subsidy rules and treasury adds are only accounted for when already mined.
+func (e *treasuryEstimator) policyLimitAt(height int64) dcrutil.Amount {
+	income := func(end int64) dcrutil.Amount {
+		return e.policy.TreasuryBases(end) + e.known(e.tadds, end)
+	}
+	spent := func(end int64) dcrutil.Amount {
+		return e.known(e.spent, end)
+	}
+	return e.policy.Limit(height, income, spent)
+}
+
+// spendableAt estimates the maximum amount a tspend included at the given
+// height may spend: the policy limit of the window ending at that height,
+// minus the tspends already mined in that window and the ones waiting in the
+// mempool.
+func (e *treasuryEstimator) spendableAt(height int64) dcrutil.Amount {
+	limit := e.policyLimitAt(height)
+	spent := e.known(e.spent, height)
+	log.Debugf("Policy window ending at %d (%s): limit %s, spent %s, "+
+		"mempool %s", height, e.policy.Rules().At(height), limit, spent,
+		e.mempool)
+	return limit - spent - e.mempool
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/jessevdk/go-flags"
+)
+
+const appName = "expiryfor"
+
+type chainNetwork string
+
+const (
+	cnMainNet chainNetwork = "mainnet"
+	cnTestNet chainNetwork = "testnet"
+	cnSimNet  chainNetwork = "simnet"
+)
+
+// defaultDcrdRPCConnect returns the default rpc connect address for the given
+// network.
+func (c chainNetwork) defaultDcrdRPCConnect() string {
+	switch c {
+	case cnMainNet:
+		return "localhost:9109"
+	case cnTestNet:
+		return "localhost:19109"
+	case cnSimNet:
+		return "localhost:19556"
+	default:
+		panic("unknown chainNetwork")
+	}
+}
+
+func (c chainNetwork) chainParams() *chaincfg.Params {
+	switch c {
+	case cnMainNet:
+		return chaincfg.MainNetParams()
+	case cnTestNet:
+		return chaincfg.TestNet3Params()
+	case cnSimNet:
+		return chaincfg.SimNetParams()
+	default:
+		panic("unknown chainNetwork")
+	}
+}
+
+const (
+	defaultActiveNet = cnMainNet
+)
+
+var (
+	defaultConfigFilename = appName + ".conf"
+	defaultConfigDir      = dcrutil.AppDataDir(appName, false)
+	defaultConfigFile     = filepath.Join(defaultConfigDir, defaultConfigFilename)
+	defaultDcrdDir        = dcrutil.AppDataDir("dcrd", false)
+	defaultDcrdCertPath   = filepath.Join(defaultDcrdDir, "rpc.cert")
+
+	errCmdDone = errors.New("cmd is done while parsing config options")
+)
+
+type config struct {
+	ConfigFile string `short:"C" long:"configfile" description:"Path to configuration file"`
+
+	// Network
+
+	MainNet bool `long:"mainnet" description:"Use the main network"`
+	TestNet bool `long:"testnet" description:"Use the test network"`
+	SimNet  bool `long:"simnet" description:"Use the simulation test network"`
+
+	// Dcrd Connection Options
+
+	DcrdConnect   string `long:"dcrdconnect" description:"Network address of the RPC interface of the dcrd node to connect to (default: localhost port 9109, testnet: 19109, simnet: 19556)"`
+	DcrdCertPath  string `long:"dcrdcertpath" description:"File path location of the dcrd RPC certificate"`
+	DcrdCertBytes string `long:"dcrdcertbytes" description:"The pem-encoded RPC certificate for dcrd"`
+	DcrdUser      string `short:"u" long:"dcrduser" description:"RPC username to authenticate with dcrd"`
+	DcrdPass      string `short:"P" long:"dcrdpass" description:"RPC password to authenticate with dcrd"`
+
+	// Planning options
+
+	Height      int64  `long:"height" description:"Block height (assumed already mined) to calculate the expiry for"`
+	FromTip     bool   `long:"fromtip" description:"Fetch the current tip from dcrd and use it as the height and as the anchor for time estimates"`
+	FromHeight  int64  `long:"fromheight" description:"First height of a range of heights to tabulate"`
+	ToHeight    int64  `long:"toheight" description:"Last height (inclusive) of a range of heights to tabulate"`
+	Step        int64  `long:"step" description:"Height increment when tabulating a range of heights"`
+	Date        string `long:"date" description:"Convert a calendar date (YYYY-MM-DD, YYYY-MM-DD HH:MM or RFC3339) to an estimated block height and use it as the height"`
+	CheckExpiry uint32 `long:"checkexpiry" description:"Validate an existing expiry value instead of calculating a new one"`
+	Format      string `long:"format" description:"Output format {text, csv, json}"`
+	ICS         string `long:"ics" description:"Write the voting window events of the resulting expiries to the specified iCalendar (.ics) file"`
+
+	// The rest of the members of this struct are filled by loadConfig().
+
+	activeNet   chainNetwork
+	chainParams *chaincfg.Params
+
+	// heightSet is true when the height was given, either with --height
+	// or as an argument, since 0 is a valid height.
+	heightSet bool
+}
+
+func (c *config) dcrdConnConfig() *rpcclient.ConnConfig {
+	return &rpcclient.ConnConfig{
+		Host:         c.DcrdConnect,
+		Endpoint:     "ws",
+		User:         c.DcrdUser,
+		Pass:         c.DcrdPass,
+		Certificates: []byte(c.DcrdCertBytes),
+	}
+}
+
+// needsDcrd returns true if the config means the app will need to connect to
+// the dcrd instance.
+func (c *config) needsDcrd() bool {
+	return c.FromTip
+}
+
+// isRange returns true if the config specifies a range of heights to
+// tabulate.
+func (c *config) isRange() bool {
+	return c.FromHeight != 0 || c.ToHeight != 0
+}
+
+func (c *config) fillActiveNet() error {
+	numNets := 0
+	c.activeNet = defaultActiveNet
+	if c.MainNet {
+		numNets++
+		c.activeNet = cnMainNet
+	}
+	if c.TestNet {
+		numNets++
+		c.activeNet = cnTestNet
+	}
+	if c.SimNet {
+		numNets++
+		c.activeNet = cnSimNet
+	}
+	if numNets > 1 {
+		return errors.New("mainnet, testnet and simnet params can't be " +
+			"used together -- choose one of the three")
+	}
+
+	c.chainParams = c.activeNet.chainParams()
+	return nil
+}
+
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		DcrdCertPath: defaultDcrdCertPath,
+		Step:         1,
+		Format:       "text",
+	}
+
+	// Pre-parse the command line options to see if an alternative config
+	// file was specified.  Any errors aside from the
+	// help message error can be ignored here since they will be caught by
+	// the final parse below.
+	preCfg := cfg
+	preParser := flags.NewParser(&preCfg, flags.HelpFlag)
+	_, err := preParser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, nil, errCmdDone
+		}
+	}
+
+	usageMessage := fmt.Sprintf("Use %s -h to show usage", appName)
+
+	// If the config file path has not been modified by user, then
+	// we'll use the default config file path.
+	if preCfg.ConfigFile == "" {
+		preCfg.ConfigFile = defaultConfigFile
+	}
+
+	// Load additional config from file.
+	parser := flags.NewParser(&cfg, flags.Default)
+	parser.Usage = "[OPTIONS] [block height]"
+
+	err = flags.NewIniParser(parser).ParseFile(preCfg.ConfigFile)
+	if err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			fmt.Fprintf(os.Stderr, "Error parsing config "+
+				"file: %v\n", err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
+	// Parse command line options again to ensure they take precedence.
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			fmt.Fprintln(os.Stderr, usageMessage)
+		}
+		return nil, nil, err
+	}
+
+	// Determine the final network.
+	if err := cfg.fillActiveNet(); err != nil {
+		return nil, nil, err
+	}
+
+	// Keep supporting the height as a positional argument.
+	cfg.heightSet = parser.FindOptionByLongName("height").IsSet()
+	if len(remainingArgs) > 0 {
+		if cfg.heightSet {
+			return nil, nil, fmt.Errorf("height specified both as " +
+				"an argument and as --height")
+		}
+		cfg.Height, err = strconv.ParseInt(remainingArgs[0], 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid block height: %v", err)
+		}
+		cfg.heightSet = true
+		remainingArgs = remainingArgs[1:]
+	}
+
+	// Validate the planning options.
+	switch cfg.Format {
+	case "text", "csv", "json":
+	default:
+		return nil, nil, fmt.Errorf("unknown output format %q", cfg.Format)
+	}
+	if cfg.Step <= 0 {
+		return nil, nil, fmt.Errorf("step must be a positive number")
+	}
+	if cfg.isRange() && cfg.ToHeight < cfg.FromHeight {
+		return nil, nil, fmt.Errorf("toheight (%d) must not be lower than "+
+			"fromheight (%d)", cfg.ToHeight, cfg.FromHeight)
+	}
+	numSources := 0
+	for _, set := range []bool{cfg.heightSet, cfg.Date != "", cfg.isRange()} {
+		if set {
+			numSources++
+		}
+	}
+	if numSources > 1 {
+		return nil, nil, fmt.Errorf("only one of height, --date or " +
+			"--fromheight/--toheight may be specified")
+	}
+	if numSources == 0 && !cfg.FromTip && cfg.CheckExpiry == 0 {
+		fmt.Fprintf(os.Stderr, "Find out the tspend expiry for a given block height\n")
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, errors.New("no block height specified")
+	}
+
+	// Only check dcrd stuff if we'll need to connect to it.
+	if cfg.needsDcrd() {
+		// Determine the default dcrd connect address based on the
+		// selected network.
+		if cfg.DcrdConnect == "" {
+			cfg.DcrdConnect = cfg.activeNet.defaultDcrdRPCConnect()
+		}
+
+		// Load the appropriate dcrd rpc.cert file.
+		if len(cfg.DcrdCertBytes) == 0 && cfg.DcrdCertPath != "" {
+			f, err := ioutil.ReadFile(cfg.DcrdCertPath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to load dcrd cert "+
+					"file: %v", err)
+			}
+			cfg.DcrdCertBytes = string(f)
+		}
+	}
+
+	return &cfg, remainingArgs, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/v3"
+)
+
+// expiryInfo is the planning information for generating a TSpend after a
+// given block height is mined.
+type expiryInfo struct {
+	Height      int64     `json:"height"`
+	Time        time.Time `json:"time"`
+	NextHeight  int64     `json:"nextheight"`
+	IsTVI       bool      `json:"istvi"`
+	BlocksToTVI int64     `json:"blockstotvi"`
+	TooClose    bool      `json:"tooclose"`
+	Expiry      uint32    `json:"expiry"`
+	VoteStart   uint32    `json:"votestart"`
+	VoteEnd     uint32    `json:"voteend"`
+	StartTime   time.Time `json:"votestarttime"`
+	EndTime     time.Time `json:"voteendtime"`
+
+	// The following are only filled when the next height is too close to
+	// the next TVI, in which case the expiry and voting window above are
+	// the ones from the following TVI.
+	CloseExpiry    uint32 `json:"closeexpiry,omitempty"`
+	CloseVoteStart uint32 `json:"closevotestart,omitempty"`
+	CloseVoteEnd   uint32 `json:"closevoteend,omitempty"`
+}
+
+// timeAnchor is a known (height, time) pair used to estimate the time of other
+// block heights based on the chain's target block time.
+type timeAnchor struct {
+	height int64
+	time   time.Time
+	perBlk time.Duration
+}
+
+// genesisAnchor returns an anchor based on the genesis block of the chain. This
+// is only a coarse estimate, given the actual block times drift from the target
+// over the life of the chain.
+func genesisAnchor(params *chaincfg.Params) timeAnchor {
+	return timeAnchor{
+		height: 0,
+		time:   params.GenesisBlock.Header.Timestamp,
+		perBlk: params.TargetTimePerBlock,
+	}
+}
+
+// heightTime returns the estimated time of the given height.
+func (a timeAnchor) heightTime(height int64) time.Time {
+	return a.time.Add(time.Duration(height-a.height) * a.perBlk)
+}
+
+// timeHeight returns the estimated height of the block mined at the given time.
+func (a timeAnchor) timeHeight(t time.Time) int64 {
+	return a.height + int64(t.Sub(a.time)/a.perBlk)
+}
+
+// parseDate parses a calendar date in one of the supported formats. Dates
+// without an explicit timezone are interpreted as UTC.
+func parseDate(s string) (time.Time, error) {
+	formats := []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"}
+	for _, f := range formats {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse date %q", s)
+}
+
+// calcExpiry calculates the expiry that should be used for a TSpend generated
+// after the given height is mined.
+func calcExpiry(params *chaincfg.Params, anchor timeAnchor, height int64) expiryInfo {
+	tvi := params.TreasuryVoteInterval
+	mul := params.TreasuryVoteIntervalMultiplier
+
+	// Assume height is mined, so start calc for height+1.
+	nextHeight := height + 1
+	blocksToTVI := int64(tvi) - (nextHeight % int64(tvi))
+	tooCloseThresh := int64(tvi / 4)
+
+	info := expiryInfo{
+		Height:      height,
+		Time:        anchor.heightTime(height),
+		NextHeight:  nextHeight,
+		IsTVI:       blockchain.IsTreasuryVoteInterval(uint64(nextHeight), tvi),
+		BlocksToTVI: blocksToTVI,
+		TooClose:    blocksToTVI < tooCloseThresh,
+	}
+
+	info.Expiry = blockchain.CalcTSpendExpiry(nextHeight, tvi, mul)
+	if info.TooClose {
+		// Advance to following TVI.
+		info.CloseExpiry = info.Expiry
+		info.CloseVoteStart, info.CloseVoteEnd, _ = blockchain.CalcTSpendWindow(
+			info.Expiry, tvi, mul)
+		info.Expiry = blockchain.CalcTSpendExpiry(nextHeight+blocksToTVI, tvi, mul)
+	}
+	info.VoteStart, info.VoteEnd, _ = blockchain.CalcTSpendWindow(info.Expiry, tvi, mul)
+	info.StartTime = anchor.heightTime(int64(info.VoteStart))
+	info.EndTime = anchor.heightTime(int64(info.VoteEnd))
+	return info
+}
+
+// expiryCheck is the result of validating an existing expiry value.
+type expiryCheck struct {
+	Expiry    uint32     `json:"expiry"`
+	Valid     bool       `json:"valid"`
+	Error     string     `json:"error,omitempty"`
+	VoteStart uint32     `json:"votestart,omitempty"`
+	VoteEnd   uint32     `json:"voteend,omitempty"`
+	StartTime *time.Time `json:"votestarttime,omitempty"`
+	EndTime   *time.Time `json:"voteendtime,omitempty"`
+
+	// The following are only filled when the height of the chain is known.
+	Height         *int64 `json:"height,omitempty"`
+	Status         string `json:"status,omitempty"`
+	ExpectedExpiry uint32 `json:"expectedexpiry,omitempty"`
+}
+
+// checkExpiry validates the given expiry. When the height of the chain is
+// known, it is also checked against the state of the chain at that height.
+func checkExpiry(params *chaincfg.Params, anchor timeAnchor, expiry uint32,
+	height int64, haveHeight bool) expiryCheck {
+
+	tvi := params.TreasuryVoteInterval
+	mul := params.TreasuryVoteIntervalMultiplier
+
+	res := expiryCheck{Expiry: expiry}
+	start, end, err := blockchain.CalcTSpendWindow(expiry, tvi, mul)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Valid = true
+	res.VoteStart, res.VoteEnd = start, end
+	startTime, endTime := anchor.heightTime(int64(start)), anchor.heightTime(int64(end))
+	res.StartTime, res.EndTime = &startTime, &endTime
+
+	if !haveHeight {
+		return res
+	}
+
+	res.Height = &height
+	res.ExpectedExpiry = calcExpiry(params, anchor, height).Expiry
+	nextHeight := uint32(height + 1)
+	switch {
+	case nextHeight >= expiry:
+		res.Status = "expired"
+	case nextHeight > start:
+		res.Status = "voting"
+	default:
+		res.Status = "pending"
+	}
+	return res
+}
@@ -0,0 +1,117 @@
+// This file is duplicated as expiryfor/ics.go and voteprogress/ics.go, since
+// every tool is a separate main package (as with signal.go). Keep both copies
+// identical.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+)
+
+// icsEvent is a single event of an iCalendar file.
+type icsEvent struct {
+	uid         string
+	summary     string
+	description string
+	start       time.Time
+	duration    time.Duration
+}
+
+// tspendEvents returns the calendar events for the voting window of a TSpend
+// with the given expiry: vote start, every TVI before the vote end where the
+// TSpend may be included in a block, vote end (also the last inclusion
+// opportunity) and expiry. The id is used to build the unique event ids and the
+// label is prefixed to every event summary.
+func tspendEvents(params *chaincfg.Params, heightTime func(int64) time.Time,
+	id, label string, expiry uint32, voteStart, voteEnd uint32) []icsEvent {
+
+	tvi := uint32(params.TreasuryVoteInterval)
+	dur := params.TargetTimePerBlock * time.Duration(tvi) / 4
+	event := func(kind, summary string, height uint32) icsEvent {
+		t := heightTime(int64(height))
+		return icsEvent{
+			uid:     fmt.Sprintf("%s-%s-%d@tspend", id, kind, height),
+			summary: fmt.Sprintf("%s: %s", label, summary),
+			description: fmt.Sprintf("Block height %d (estimated "+
+				"time %s). Expiry %d, voting window %d - %d.",
+				height, t.UTC().Format(time.RFC3339), expiry,
+				voteStart, voteEnd),
+			start:    t,
+			duration: dur,
+		}
+	}
+
+	events := []icsEvent{event("votestart", "vote start", voteStart)}
+	for h := voteStart + tvi; h < voteEnd; h += tvi {
+		events = append(events, event("tvi", "inclusion opportunity", h))
+	}
+	events = append(events, event("voteend", "vote end", voteEnd))
+	events = append(events, event("expiry", "expiry", expiry))
+	return events
+}
+
+// icsEscape escapes a text value according to RFC 5545.
+func icsEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// icsLine writes a content line, folding it so that no line is longer than 75
+// octets as required by RFC 5545. Continuation lines start with a space, so
+// they hold at most 74 octets of content. Lines are only folded between UTF-8
+// characters.
+func icsLine(w *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		w.WriteString(line[:n])
+		w.WriteString("\r\n ")
+		line = line[n:]
+		limit = 74
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+// writeICS writes the events as an iCalendar file at the given path.
+func writeICS(path string, events []icsEvent) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating ics file: %v", err)
+	}
+
+	const tsFormat = "20060102T150405Z"
+	stamp := time.Now().UTC().Format(tsFormat)
+	w := bufio.NewWriter(f)
+	icsLine(w, "BEGIN:VCALENDAR")
+	icsLine(w, "VERSION:2.0")
+	icsLine(w, "PRODID:-//matheusd//tspend//EN")
+	icsLine(w, "CALSCALE:GREGORIAN")
+	for _, e := range events {
+		icsLine(w, "BEGIN:VEVENT")
+		icsLine(w, "UID:"+e.uid)
+		icsLine(w, "DTSTAMP:"+stamp)
+		icsLine(w, "DTSTART:"+e.start.UTC().Format(tsFormat))
+		icsLine(w, "DTEND:"+e.start.Add(e.duration).UTC().Format(tsFormat))
+		icsLine(w, "SUMMARY:"+icsEscape(e.summary))
+		icsLine(w, "DESCRIPTION:"+icsEscape(e.description))
+		icsLine(w, "END:VEVENT")
+	}
+	icsLine(w, "END:VCALENDAR")
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,62 +1,280 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strconv"
+	"time"
 
 	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
 	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/rpcclient/v8"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Printf("Usage: %s [--simnet|--testnet] [block height]\n", filepath.Base(os.Args[0]))
-		fmt.Println("Find out the tspend expiry for a given block height")
-		os.Exit(1)
+// fetchTipAnchor fetches the current tip from dcrd and returns it as a time
+// anchor.
+func fetchTipAnchor(ctx context.Context, cfg *config) (timeAnchor, error) {
+	connCfg := cfg.dcrdConnConfig()
+	connCfg.DisableConnectOnNew = true
+	connCfg.DisableAutoReconnect = true
+	connCfg.HTTPPostMode = false
+	c, err := rpcclient.New(connCfg, nil)
+	if err != nil {
+		return timeAnchor{}, fmt.Errorf("unable to create dcrd client: %v", err)
+	}
+	defer c.Shutdown()
+
+	connCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err = c.Connect(connCtx, false)
+	if err != nil {
+		return timeAnchor{}, fmt.Errorf("unable to connect to dcrd: %v", err)
 	}
 
-	chain := chaincfg.MainNetParams()
-	heightStr := os.Args[1]
-	if os.Args[1] == "--testnet" {
-		chain = chaincfg.TestNet3Params()
-		heightStr = os.Args[2]
-	} else if os.Args[1] == "--simnet" {
-		chain = chaincfg.SimNetParams()
-		heightStr = os.Args[2]
+	binfo, err := c.GetBlockChainInfo(ctx)
+	if err != nil {
+		return timeAnchor{}, err
+	}
+	if binfo.Chain != cfg.chainParams.Name {
+		return timeAnchor{}, fmt.Errorf("invalid dcrd chain: want %s, got %s",
+			cfg.chainParams.Name, binfo.Chain)
 	}
 
-	height, err := strconv.ParseInt(heightStr, 10, 32)
+	tipHash, _, err := c.GetBestBlock(ctx)
 	if err != nil {
+		return timeAnchor{}, err
+	}
+	header, err := c.GetBlockHeader(ctx, tipHash)
+	if err != nil {
+		return timeAnchor{}, err
+	}
+
+	return timeAnchor{
+		height: int64(header.Height),
+		time:   header.Timestamp,
+		perBlk: cfg.chainParams.TargetTimePerBlock,
+	}, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
+
+func printTextInfo(params *chaincfg.Params, info expiryInfo) {
+	tvi := params.TreasuryVoteInterval
+	fmt.Printf("Height %d: IsTVI: %v\n", info.NextHeight, info.IsTVI)
+	fmt.Printf("To TVI: %d (thresh %d)\n", info.BlocksToTVI, tvi/4)
+	if !info.TooClose {
+		fmt.Printf("Expiry: %d\n", info.Expiry)
+		fmt.Printf("Voting interval: %d - %d\n", info.VoteStart, info.VoteEnd)
+		fmt.Printf("Estimated voting dates: %s - %s\n",
+			formatTime(info.StartTime), formatTime(info.EndTime))
+		return
+	}
+
+	fmt.Printf("Expiry: %d\n", info.CloseExpiry)
+	fmt.Printf("Voting interval: %d - %d\n", info.CloseVoteStart, info.CloseVoteEnd)
+	fmt.Println("\nHeight too close to TVI. Advancing to next one.")
+	fmt.Printf("Expiry: %d\n", info.Expiry)
+	fmt.Printf("Voting interval: %d - %d\n", info.VoteStart, info.VoteEnd)
+	fmt.Printf("Estimated voting dates: %s - %s\n",
+		formatTime(info.StartTime), formatTime(info.EndTime))
+}
+
+func printTextCheck(check expiryCheck) {
+	if !check.Valid {
+		fmt.Printf("Expiry %d is invalid: %s\n", check.Expiry, check.Error)
+		return
+	}
+	fmt.Printf("Expiry %d is valid\n", check.Expiry)
+	fmt.Printf("Voting interval: %d - %d\n", check.VoteStart, check.VoteEnd)
+	fmt.Printf("Estimated voting dates: %s - %s\n",
+		formatTime(*check.StartTime), formatTime(*check.EndTime))
+	if check.Height == nil {
+		return
+	}
+	height := *check.Height
+	switch check.Status {
+	case "expired":
+		fmt.Printf("At height %d the expiry has already been reached\n", height)
+	case "voting":
+		fmt.Printf("At height %d the voting window has already started\n", height)
+	default:
+		fmt.Printf("At height %d the voting window has not started yet\n", height)
+	}
+	if check.ExpectedExpiry != check.Expiry {
+		fmt.Printf("Expiry for a TSpend generated at height %d would be %d\n",
+			height, check.ExpectedExpiry)
+	}
+}
+
+func writeCSV(infos []expiryInfo) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"height", "time", "expiry", "vote_start", "vote_end",
+		"vote_start_time", "vote_end_time", "too_close"})
+	for _, info := range infos {
+		w.Write([]string{
+			strconv.FormatInt(info.Height, 10),
+			info.Time.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(info.Expiry), 10),
+			strconv.FormatUint(uint64(info.VoteStart), 10),
+			strconv.FormatUint(uint64(info.VoteEnd), 10),
+			info.StartTime.UTC().Format(time.RFC3339),
+			info.EndTime.UTC().Format(time.RFC3339),
+			strconv.FormatBool(info.TooClose),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeExpiryICS writes the calendar events for the voting windows of the
+// given expiries.
+func writeExpiryICS(path string, params *chaincfg.Params, anchor timeAnchor,
+	expiries []uint32) error {
+
+	tvi := params.TreasuryVoteInterval
+	mul := params.TreasuryVoteIntervalMultiplier
+	var events []icsEvent
+	for _, expiry := range expiries {
+		start, end, err := blockchain.CalcTSpendWindow(expiry, tvi, mul)
+		if err != nil {
+			return err
+		}
+		id := fmt.Sprintf("%s-expiry-%d", params.Name, expiry)
+		label := fmt.Sprintf("TSpend expiry %d", expiry)
+		events = append(events, tspendEvents(params, anchor.heightTime,
+			id, label, expiry, start, end)...)
+	}
+	return writeICS(path, events)
+}
+
+// realMain is the real entrypoint for the app.
+func realMain() error {
+	cfg, _, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	params := cfg.chainParams
+
+	// Determine the anchor used for time estimates.
+	anchor := genesisAnchor(params)
+	if cfg.FromTip {
+		anchor, err = fetchTipAnchor(context.Background(), cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Determine the target height (if any).
+	height, haveHeight := cfg.Height, cfg.heightSet
+	switch {
+	case cfg.Date != "":
+		date, err := parseDate(cfg.Date)
+		if err != nil {
+			return err
+		}
+		height, haveHeight = anchor.timeHeight(date), true
+		switch {
+		case height <= 0:
+			return fmt.Errorf("date %s is before the first block of "+
+				"the chain (estimated height %d)", formatTime(date),
+				height)
+		case cfg.FromTip && height < anchor.height:
+			return fmt.Errorf("date %s is in the past: its estimated "+
+				"height %d is below the current tip %d",
+				formatTime(date), height, anchor.height)
+		}
+		if cfg.Format == "text" {
+			fmt.Printf("Estimated height for %s: %d\n", formatTime(date), height)
+		}
+	case !haveHeight && cfg.FromTip:
+		height, haveHeight = anchor.height, true
+	}
+
+	if cfg.Format == "text" {
+		fmt.Printf("Chain: %s TVI %d MUL %d\n", params.Name,
+			params.TreasuryVoteInterval, params.TreasuryVoteIntervalMultiplier)
+	}
+
+	// Validate an existing expiry.
+	if cfg.CheckExpiry != 0 {
+		check := checkExpiry(params, anchor, cfg.CheckExpiry, height,
+			haveHeight)
+		switch cfg.Format {
+		case "text":
+			printTextCheck(check)
+		case "json":
+			err = writeJSON(check)
+		case "csv":
+			err = fmt.Errorf("csv format not supported when checking an expiry")
+		}
+		if err == nil && !check.Valid {
+			err = errors.New("invalid expiry")
+		}
+		if err == nil && cfg.ICS != "" {
+			err = writeExpiryICS(cfg.ICS, params, anchor, []uint32{check.Expiry})
+		}
+		return err
+	}
+
+	// Build the list of heights.
+	var infos []expiryInfo
+	if cfg.isRange() {
+		for h := cfg.FromHeight; h <= cfg.ToHeight; h += cfg.Step {
+			infos = append(infos, calcExpiry(params, anchor, h))
+		}
+	} else {
+		infos = append(infos, calcExpiry(params, anchor, height))
+	}
+
+	// Write the calendar for every distinct expiry.
+	if cfg.ICS != "" {
+		var expiries []uint32
+		for _, info := range infos {
+			if len(expiries) == 0 || expiries[len(expiries)-1] != info.Expiry {
+				expiries = append(expiries, info.Expiry)
+			}
+		}
+		err := writeExpiryICS(cfg.ICS, params, anchor, expiries)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch cfg.Format {
+	case "csv":
+		return writeCSV(infos)
+	case "json":
+		if !cfg.isRange() {
+			return writeJSON(infos[0])
+		}
+		return writeJSON(infos)
+	}
+
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println("")
+		}
+		printTextInfo(params, info)
+	}
+	return nil
+}
+
+func main() {
+	err := realMain()
+	if err != nil && !errors.Is(err, errCmdDone) {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	height += 1 // Assume height is mined, so start calc for height+1.
-	tvi := chain.TreasuryVoteInterval
-	mul := chain.TreasuryVoteIntervalMultiplier
-
-	fmt.Printf("Chain: %s TVI %d MUL %d\n", chain.Name, tvi, mul)
-
-	blocksToTVI := int64(tvi) - (height % int64(tvi))
-	tooCloseThresh := int64(tvi / 4)
-	isTooClose := blocksToTVI < tooCloseThresh
-
-	isTVI := blockchain.IsTreasuryVoteInterval(uint64(height), tvi)
-	expiry := blockchain.CalcTSpendExpiry(height, tvi, mul)
-	start, end, _ := blockchain.CalcTSpendWindow(expiry, tvi, mul)
-
-	fmt.Printf("Height %d: IsTVI: %v\n", height, isTVI)
-	fmt.Printf("To TVI: %d (thresh %d)\n", blocksToTVI, tooCloseThresh)
-	fmt.Printf("Expiry: %d\n", expiry)
-	fmt.Printf("Voting interval: %d - %d\n", start, end)
-	if isTooClose {
-		// Advance to following TVI.
-		height += blocksToTVI
-		expiry = blockchain.CalcTSpendExpiry(height, tvi, mul)
-		start, end, _ = blockchain.CalcTSpendWindow(expiry, tvi, mul)
-		fmt.Println("\nHeight too close to TVI. Advancing to next one.")
-		fmt.Printf("Expiry: %d\n", expiry)
-		fmt.Printf("Voting interval: %d - %d\n", start, end)
-	}
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// fiatAmountRE matches an amount in a fiat currency, such as "1500.50 USD".
+var fiatAmountRE = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([A-Za-z]{3})$`)
+
+// exchangeRate is the price of one DCR in a fiat currency, along with where
+// and when it was obtained.
+type exchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      string    `json:"rate"`
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+
+	rate *big.Rat
+}
+
+// fiatRounding is how fiat conversions are rounded to an amount in atoms.
+const fiatRounding = "floor"
+
+// fiatConversion records the conversion of a fiat amount into atoms. Exact is
+// the unrounded value in atoms (to 8 decimal places) and Amount is that value
+// rounded as described by Rounding.
+type fiatConversion struct {
+	Name     string         `json:"name,omitempty"`
+	Address  string         `json:"address"`
+	Fiat     string         `json:"fiat"`
+	Currency string         `json:"currency"`
+	Exact    string         `json:"exact"`
+	Rounding string         `json:"rounding"`
+	Amount   dcrutil.Amount `json:"amount"`
+	Rate     *exchangeRate  `json:"rate"`
+}
+
+// parseFiatAmount parses an amount in a fiat currency, returning the amount,
+// its decimal representation and the currency code. It returns a nil amount
+// when s is not a fiat amount, which includes amounts suffixed with DCR.
+func parseFiatAmount(s string) (*big.Rat, string, string) {
+	m := fiatAmountRE.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || strings.EqualFold(m[2], "DCR") {
+		return nil, "", ""
+	}
+	amount, ok := new(big.Rat).SetString(m[1])
+	if !ok {
+		return nil, "", ""
+	}
+	return amount, m[1], strings.ToUpper(m[2])
+}
+
+// loadExchangeRate loads the exchange rate from the rate file or from the
+// config flags. The source and timestamp are mandatory, so that conversions
+// can be audited later.
+func loadExchangeRate(cfg *config) (*exchangeRate, error) {
+	var rate exchangeRate
+	if cfg.RateFile != "" {
+		b, err := os.ReadFile(cfg.RateFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &rate); err != nil {
+			return nil, fmt.Errorf("unable to decode rate file: %v", err)
+		}
+	} else {
+		rate.Currency = cfg.RateCurrency
+		rate.Rate = cfg.Rate
+		rate.Source = cfg.RateSource
+		if cfg.RateTime != "" {
+			var err error
+			rate.Timestamp, err = time.Parse(time.RFC3339, cfg.RateTime)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate timestamp: %v", err)
+			}
+		}
+	}
+
+	switch {
+	case rate.Rate == "":
+		return nil, fmt.Errorf("fiat payouts require an exchange rate " +
+			"(--rate or --ratefile)")
+	case rate.Source == "":
+		return nil, fmt.Errorf("the source of the exchange rate must " +
+			"be specified")
+	case rate.Timestamp.IsZero():
+		return nil, fmt.Errorf("the timestamp of the exchange rate " +
+			"must be specified")
+	}
+	rate.Currency = strings.ToUpper(strings.TrimSpace(rate.Currency))
+	var ok bool
+	rate.rate, ok = new(big.Rat).SetString(strings.TrimSpace(rate.Rate))
+	if !ok || rate.rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q", rate.Rate)
+	}
+	return &rate, nil
+}
+
+// exactAtoms returns the unrounded value in atoms of a fiat amount at the given
+// rate.
+func (r *exchangeRate) exactAtoms(fiat *big.Rat) *big.Rat {
+	atoms := new(big.Rat).Mul(fiat, big.NewRat(dcrutil.AtomsPerCoin, 1))
+	return atoms.Quo(atoms, r.rate)
+}
+
+// toAtoms converts a fiat amount into atoms at the given rate, rounding down
+// to the nearest atom so that a payout never exceeds its fiat value. Amounts
+// worth more than the maximum amount of coins are rejected.
+func (r *exchangeRate) toAtoms(fiat *big.Rat) (dcrutil.Amount, error) {
+	exact := r.exactAtoms(fiat)
+	atoms := new(big.Int).Quo(exact.Num(), exact.Denom())
+	if !atoms.IsInt64() || atoms.Int64() > dcrutil.MaxAmount {
+		return 0, fmt.Errorf("%s %s is worth more than the maximum "+
+			"amount of %s", fiat.FloatString(8), r.Currency,
+			dcrutil.Amount(dcrutil.MaxAmount))
+	}
+	return dcrutil.Amount(atoms.Int64()), nil
+}
+
+// logConversions logs the exchange rate and the fiat conversions of the
+// payouts, if any.
+func logConversions(payouts []*payout) {
+	var rate *exchangeRate
+	for _, p := range payouts {
+		if p.fiat == nil {
+			continue
+		}
+		if rate == nil {
+			rate = p.fiat.Rate
+			log.Infof("Exchange rate: 1 DCR = %s %s (source: %s, at %s)",
+				rate.Rate, rate.Currency, rate.Source,
+				rate.Timestamp.Format(time.RFC3339))
+		}
+		log.Infof("Payout to %s: %s %s = %s",
+			payeeString(p.name, p.address), p.fiat.Fiat,
+			p.fiat.Currency, p.amount)
+	}
+}
+
+// fiatConversions returns the fiat conversions of the payouts.
+func fiatConversions(payouts []*payout) []*fiatConversion {
+	var convs []*fiatConversion
+	for _, p := range payouts {
+		if p.fiat != nil {
+			convs = append(convs, p.fiat)
+		}
+	}
+	return convs
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// TestFiatRounding ensures fiat amounts are rounded down to the nearest atom
+// at the boundaries between atoms and that the conversions recorded in the
+// manifest hold the unrounded value and the rounding applied.
+func TestFiatRounding(t *testing.T) {
+	params := chaincfg.SimNetParams()
+	cfg := &config{
+		chainParams:  params,
+		Rate:         "20",
+		RateCurrency: "USD",
+		RateSource:   "test",
+		RateTime:     "2026-01-01T00:00:00Z",
+	}
+	addr, err := parseStakeAddress("SsWKp7wtdTZYabYFYSc9cnxhwFEjA5g4pFc", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One atom is worth 0.0000002 USD at a rate of 20 USD/DCR.
+	tests := []struct {
+		fiat  string
+		exact string
+		atoms dcrutil.Amount
+	}{
+		{"0.0000002", "1.00000000", 1},
+		{"0.0000003999", "1.99950000", 1},
+		{"0.0000004", "2.00000000", 2},
+		{"0.0000004001", "2.00050000", 2},
+		{"20", "100000000.00000000", dcrutil.AtomsPerCoin},
+		{"19.9999999999", "99999999.99950000", dcrutil.AtomsPerCoin - 1},
+	}
+	for _, test := range tests {
+		spec, err := parseAmountSpec(addr, test.fiat+" USD")
+		if err != nil {
+			t.Fatalf("%s: %v", test.fiat, err)
+		}
+		payouts, err := resolvePayouts(cfg, []*payoutSpec{spec}, "")
+		if err != nil {
+			t.Fatalf("%s: %v", test.fiat, err)
+		}
+		p := payouts[0]
+		if p.amount != test.atoms {
+			t.Fatalf("%s: got %d atoms, want %d", test.fiat,
+				int64(p.amount), int64(test.atoms))
+		}
+		conv := p.fiat
+		if conv == nil {
+			t.Fatalf("%s: conversion not recorded", test.fiat)
+		}
+		if conv.Exact != test.exact || conv.Rounding != fiatRounding ||
+			conv.Amount != test.atoms {
+			t.Fatalf("%s: got conversion %s %s -> %d, want %s %s -> %d",
+				test.fiat, conv.Exact, conv.Rounding,
+				int64(conv.Amount), test.exact, fiatRounding,
+				int64(test.atoms))
+		}
+	}
+
+	// Amounts worth less than one atom are rejected.
+	spec, err := parseAmountSpec(addr, "0.0000001999 USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolvePayouts(cfg, []*payoutSpec{spec}, ""); err == nil {
+		t.Fatal("amount worth less than one atom was not rejected")
+	}
+
+	// Amounts worth more than the maximum amount, including ones that do
+	// not fit in an int64 of atoms, are rejected.
+	for _, fiat := range []string{"420000000.0000002", "100000000000000000000"} {
+		spec, err := parseAmountSpec(addr, fiat+" USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := resolvePayouts(cfg, []*payoutSpec{spec}, ""); err == nil {
+			t.Fatalf("%s: amount over the maximum was not rejected", fiat)
+		}
+	}
+}
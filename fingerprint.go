@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/crypto/blake256"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+)
+
+const (
+	// fingerprintSize is the number of bytes of the hash used in address
+	// fingerprints (80 bits).
+	fingerprintSize = 10
+
+	// fingerprintGroup is the number of characters per group of an address
+	// fingerprint.
+	fingerprintGroup = 4
+)
+
+// fingerprintEncoding is the encoding of address fingerprints. The base32
+// alphabet is case insensitive and has no digits that may be mistaken for
+// letters (0, 1 and 8).
+var fingerprintEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// addrFingerprint returns a short fingerprint of an address that can be read
+// out loud to confirm it with its owner, such as "XD5S-I3NJ-LSPD-ABWL".
+//
+// The fingerprint is derived from the payment script of the decoded address,
+// which commits to the address type and its hash, rather than from its string
+// encoding. It is the first 10 bytes of
+// blake256("tspend fingerprint" || script version || script), encoded in
+// base32 in groups of 4 characters.
+func addrFingerprint(addr stdaddr.Address) string {
+	version, script := addr.PaymentScript()
+	h := blake256.New()
+	h.Write([]byte("tspend fingerprint"))
+	var vb [2]byte
+	binary.BigEndian.PutUint16(vb[:], version)
+	h.Write(vb[:])
+	h.Write(script)
+	enc := fingerprintEncoding.EncodeToString(h.Sum(nil)[:fingerprintSize])
+
+	groups := make([]string, 0, len(enc)/fingerprintGroup)
+	for i := 0; i < len(enc); i += fingerprintGroup {
+		groups = append(groups, enc[i:i+fingerprintGroup])
+	}
+	return strings.Join(groups, "-")
+}
+
+// runFingerprintCmd runs the fingerprint command, which prints the fingerprint
+// of each of the given addresses so that recipients may compute the
+// fingerprint of their own addresses.
+func runFingerprintCmd(cfg *config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fingerprint <address>...")
+	}
+	for _, s := range args {
+		addr, err := parseStakeAddress(s, cfg.chainParams)
+		if err != nil {
+			return fmt.Errorf("invalid address %s: %v", s, err)
+		}
+		fmt.Printf("%s %s\n", addr, addrFingerprint(addr))
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+	"github.com/decred/dcrd/txscript/v4/stdscript"
+	"github.com/decred/dcrd/wire"
+)
+
+// readTSpends reads hex encoded tspends, one per line, from the given file or
+// stdin when path is "-".
+func readTSpends(path string) ([]*wire.MsgTx, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var msgTxs []*wire.MsgTx
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, wire.MaxBlockPayload*2)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		rawTx, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		var msgTx wire.MsgTx
+		if err := msgTx.FromBytes(rawTx); err != nil {
+			return nil, fmt.Errorf("line %d: unable to decode tx: %v",
+				line, err)
+		}
+		msgTxs = append(msgTxs, &msgTx)
+	}
+	return msgTxs, scanner.Err()
+}
+
+// tspendPayouts decodes the payouts of the OP_TGEN outputs of a tspend.
+func tspendPayouts(msgTx *wire.MsgTx, params stdaddr.AddressParamsV0) ([]*payout, error) {
+	if msgTx.Version != wire.TxVersionTreasury || len(msgTx.TxOut) < 2 {
+		return nil, fmt.Errorf("tx %s is not a tspend", msgTx.TxHash())
+	}
+	payouts := make([]*payout, 0, len(msgTx.TxOut)-1)
+	for i, txOut := range msgTx.TxOut[1:] {
+		script := txOut.PkScript
+		if txOut.Version != 0 || len(script) < 1 || script[0] != txscript.OP_TGEN {
+			return nil, fmt.Errorf("output %d is not an OP_TGEN output", i+1)
+		}
+		_, addrs := stdscript.ExtractAddrsV0(script[1:], params)
+		if len(addrs) != 1 {
+			return nil, fmt.Errorf("output %d does not pay to a single "+
+				"address", i+1)
+		}
+		stakeAddr, ok := addrs[0].(stdaddr.StakeAddress)
+		if !ok {
+			return nil, fmt.Errorf("output %d does not pay to a "+
+				"stakeable address", i+1)
+		}
+		payouts = append(payouts, &payout{
+			address: stakeAddr,
+			amount:  dcrutil.Amount(txOut.Value),
+		})
+	}
+	return payouts, nil
+}
+
+// runInspectCmd runs the inspect command, which describes the tspends read
+// from the given file (or stdin), including the fingerprint of the address of
+// each payout.
+func runInspectCmd(cfg *config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: inspect <file|->")
+	}
+	msgTxs, err := readTSpends(args[0])
+	if err != nil {
+		return err
+	}
+	book, err := loadAddrBook(cfg.AddrBook, cfg.chainParams)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(book.Payees))
+	for name, addr := range book.Payees {
+		names[addr] = name
+	}
+
+	tvi := cfg.chainParams.TreasuryVoteInterval
+	mul := cfg.chainParams.TreasuryVoteIntervalMultiplier
+	for _, msgTx := range msgTxs {
+		payouts, err := tspendPayouts(msgTx, cfg.chainParams)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("TSpend %s\n", msgTx.TxHash())
+		start, end, err := blockchain.CalcTSpendWindow(msgTx.Expiry, tvi, mul)
+		if err != nil {
+			fmt.Printf("  Expiry:          %d (invalid: %v)\n",
+				msgTx.Expiry, err)
+		} else {
+			fmt.Printf("  Expiry:          %d (voting interval %d - %d)\n",
+				msgTx.Expiry, start, end)
+		}
+		if len(msgTx.TxIn) != 1 {
+			return fmt.Errorf("tx %s does not have a single input",
+				msgTx.TxHash())
+		}
+		if len(msgTx.TxIn[0].SignatureScript) == 0 {
+			fmt.Printf("  Signed:          no\n")
+		} else if pubKey, err := CheckSignedTSpend(msgTx, cfg.chainParams); err != nil {
+			fmt.Printf("  Signed:          invalid (%v)\n", err)
+		} else {
+			fmt.Printf("  Signed by:       %x (Pi key: %v)\n", pubKey,
+				isPiKey(cfg.chainParams, pubKey))
+		}
+		fmt.Printf("  OP_RETURN:       %x\n", msgTx.TxOut[0].PkScript)
+
+		var total dcrutil.Amount
+		fmt.Printf("  Payouts:\n")
+		for _, p := range payouts {
+			p.name = names[p.address.String()]
+			fmt.Printf("    %-48s %16s  %s\n", payeeString(p.name, p.address),
+				p.amount, addrFingerprint(p.address))
+			total += p.amount
+		}
+		valueIn := dcrutil.Amount(msgTx.TxIn[0].ValueIn)
+		fmt.Printf("  Total payouts:   %s\n", total)
+		fmt.Printf("  Value in:        %s\n", valueIn)
+		fmt.Printf("  Fee:             %s\n", valueIn-total)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package treasury
+
+import (
+	"context"
+	"sync"
+)
+
+// ScanChunkSize is the number of consecutive heights processed by a worker at
+// a time.
+const ScanChunkSize = 64
+
+// RunChunked calls f for every height, splitting the heights in chunks of
+// consecutive heights processed by a bounded number of concurrent workers.
+func RunChunked(ctx context.Context, heights []uint32, workers int, f func(uint32) error) error {
+	if len(heights) == 0 {
+		return nil
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	chunks := make(chan []uint32)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				for _, h := range chunk {
+					if err := f(h); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	var err error
+loop:
+	for i := 0; i < len(heights); i += ScanChunkSize {
+		end := i + ScanChunkSize
+		if end > len(heights) {
+			end = len(heights)
+		}
+		select {
+		case chunks <- heights[i:end]:
+		case err = <-errs:
+			break loop
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package treasury
+
+import (
+	"github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// Policy estimates the treasury expenditure policy under the consensus rules
+// in effect at each height.
+type Policy struct {
+	params   *chaincfg.Params
+	subCache *standalone.SubsidyCache
+	rules    ConsensusRules
+
+	// Window is the number of blocks of a policy window.
+	Window int64
+}
+
+// NewPolicy returns the expenditure policy of the given network under the
+// given consensus rules.
+func NewPolicy(params *chaincfg.Params, rules ConsensusRules) *Policy {
+	tvi := int64(params.TreasuryVoteInterval)
+	mul := int64(params.TreasuryVoteIntervalMultiplier)
+	return &Policy{
+		params:   params,
+		subCache: standalone.NewSubsidyCache(params),
+		rules:    rules,
+		Window:   tvi * mul * int64(params.TreasuryExpenditureWindow),
+	}
+}
+
+// Rules returns the consensus rules the policy is estimated under.
+func (p *Policy) Rules() ConsensusRules {
+	return p.rules
+}
+
+// TreasuryBase returns the treasury base of the given height.
+func (p *Policy) TreasuryBase(height int64) dcrutil.Amount {
+	if p.rules.At(height) == RulesPreTreasury {
+		return 0
+	}
+	return dcrutil.Amount(p.subCache.CalcTreasurySubsidy(height,
+		p.params.TicketsPerBlock, true))
+}
+
+// TreasuryBases sums the treasury bases of the policy window ending at the
+// given height, taking into account subsidy reductions that happen along the
+// way. Blocks before the treasury activation do not add to the treasury, so
+// they are not included in the sum.
+func (p *Policy) TreasuryBases(endHeight int64) dcrutil.Amount {
+	if p.rules.TreasuryHeight < 0 {
+		return 0
+	}
+
+	reductionInterval := p.params.SubsidyReductionInterval
+	var res int64
+	height := endHeight - p.Window + 1
+	if height < p.rules.TreasuryHeight {
+		height = p.rules.TreasuryHeight
+	}
+	for height <= endHeight {
+		blocksToAdd := reductionInterval - (height % reductionInterval)
+		if height+blocksToAdd > endHeight {
+			blocksToAdd = endHeight - height + 1
+		}
+		tbase := p.subCache.CalcTreasurySubsidy(height,
+			p.params.TicketsPerBlock, true)
+		res += tbase * blocksToAdd
+		height += blocksToAdd
+	}
+	return dcrutil.Amount(res)
+}
+
+// Limit returns the maximum expenditure of the policy window ending at the
+// given height, according to the rules in effect at that height. Under
+// DCP0007, this is the income of the window plus 50%. Under DCP0006, this is
+// based on the amounts spent in the previous windows.
+//
+// income and spent return the income (treasury bases and adds) and the
+// expenditure (tspends, including fees) of the policy window ending at a given
+// height.
+func (p *Policy) Limit(height int64, income, spent func(endHeight int64) dcrutil.Amount) dcrutil.Amount {
+	switch p.rules.At(height) {
+	case RulesDCP0007:
+		windowIncome := income(height)
+		return windowIncome + windowIncome/2
+	case RulesDCP0006:
+		nbWindows := int64(p.params.TreasuryExpenditurePolicy)
+		priorWindows := make([]dcrutil.Amount, nbWindows)
+		for i := range priorWindows {
+			priorWindows[i] = spent(height - p.Window*int64(i+1))
+		}
+		return DCP0006Limit(p.params, priorWindows)
+	}
+	return 0
+}
+
+// DCP0006Limit returns the maximum expenditure of a policy window under the
+// original (DCP0006) treasury policy, given the amounts spent in each of the
+// previous policy windows: the average of the non-empty windows (or the
+// bootstrap amount if all of them are empty) plus 50%.
+func DCP0006Limit(params *chaincfg.Params, priorWindows []dcrutil.Amount) dcrutil.Amount {
+	var sum, nonEmpty dcrutil.Amount
+	for _, spent := range priorWindows {
+		if spent > 0 {
+			sum += spent
+			nonEmpty++
+		}
+	}
+	avg := dcrutil.Amount(params.TreasuryExpenditureBootstrap)
+	if nonEmpty > 0 {
+		avg = sum / nonEmpty
+	}
+	return avg + avg/2
+}
@@ -0,0 +1,90 @@
+package treasury
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// TestTreasuryBases ensures the treasury bases of a policy window are summed
+// across subsidy reductions and exclude the blocks before the treasury
+// activation.
+func TestTreasuryBases(t *testing.T) {
+	params := chaincfg.MainNetParams()
+	rules := ConsensusRules{TreasuryHeight: 552448, DCP0007Height: -1}
+	p := NewPolicy(params, rules)
+	sri := params.SubsidyReductionInterval
+	heights := []int64{
+		rules.TreasuryHeight,
+		rules.TreasuryHeight + p.Window/2,
+		rules.TreasuryHeight + 3*p.Window,
+		(rules.TreasuryHeight/sri + 10) * sri,
+		(rules.TreasuryHeight/sri+10)*sri + 1,
+		(rules.TreasuryHeight/sri+10)*sri - 1,
+	}
+	for _, height := range heights {
+		var want dcrutil.Amount
+		for h := height - p.Window + 1; h <= height; h++ {
+			want += p.TreasuryBase(h)
+		}
+		if got := p.TreasuryBases(height); got != want {
+			t.Fatalf("height %d: got %s, want %s", height, got, want)
+		}
+	}
+
+	p = NewPolicy(params, ConsensusRules{TreasuryHeight: -1, DCP0007Height: -1})
+	if got := p.TreasuryBases(rules.TreasuryHeight); got != 0 {
+		t.Fatalf("got %s of treasury bases without a treasury", got)
+	}
+}
+
+// TestPolicyLimit ensures the expenditure limit follows the rules in effect at
+// each height.
+func TestPolicyLimit(t *testing.T) {
+	params := chaincfg.SimNetParams()
+	rules := ConsensusRules{TreasuryHeight: 1000, DCP0007Height: 100000}
+	p := NewPolicy(params, rules)
+	income := func(int64) dcrutil.Amount { return 1000 }
+	spentIn := func(spent ...dcrutil.Amount) func(int64) dcrutil.Amount {
+		return func(end int64) dcrutil.Amount {
+			i := (90000 - end) / p.Window
+			if i < 1 || int(i) > len(spent) {
+				t.Fatalf("unexpected window ending at %d", end)
+			}
+			return spent[i-1]
+		}
+	}
+	bootstrap := dcrutil.Amount(params.TreasuryExpenditureBootstrap)
+	prior := make([]dcrutil.Amount, params.TreasuryExpenditurePolicy)
+
+	tests := []struct {
+		name   string
+		height int64
+		spent  func(int64) dcrutil.Amount
+		want   dcrutil.Amount
+	}{{
+		name:   "pre-treasury",
+		height: 999,
+		spent:  spentIn(prior...),
+	}, {
+		name:   "DCP0006 without prior spending",
+		height: 90000,
+		spent:  spentIn(prior...),
+		want:   bootstrap + bootstrap/2,
+	}, {
+		name:   "DCP0006 averages non-empty windows",
+		height: 90000,
+		spent:  spentIn(append([]dcrutil.Amount{100, 0, 300}, prior[3:]...)...),
+		want:   300,
+	}, {
+		name:   "DCP0007",
+		height: 100000,
+		want:   1500,
+	}}
+	for _, test := range tests {
+		if got := p.Limit(test.height, income, test.spent); got != test.want {
+			t.Fatalf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
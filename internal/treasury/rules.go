@@ -0,0 +1,60 @@
+// Package treasury implements the selection of the treasury consensus rules
+// and the estimation of the treasury expenditure policy shared by the tspend
+// tools.
+package treasury
+
+import (
+	"github.com/decred/dcrd/chaincfg/v3"
+	chainjson "github.com/decred/dcrd/rpc/jsonrpc/types/v4"
+)
+
+// Names of the treasury consensus rules that may be in effect at a height.
+const (
+	RulesPreTreasury = "pre-treasury"
+	RulesDCP0006     = "DCP0006"
+	RulesDCP0007     = "DCP0007"
+)
+
+// ConsensusRules tracks the heights where the agendas that change the
+// treasury rules activate. A negative height means the agenda is neither
+// active nor locked in.
+type ConsensusRules struct {
+	TreasuryHeight int64
+	DCP0007Height  int64
+}
+
+// AgendaHeight returns the height the given agenda activates (or activated)
+// at, according to the deployment status reported by dcrd.
+func AgendaHeight(binfo *chainjson.GetBlockChainInfoResult, params *chaincfg.Params, id string) int64 {
+	agenda, ok := binfo.Deployments[id]
+	if !ok {
+		return -1
+	}
+	switch agenda.Status {
+	case "active":
+		return agenda.Since
+	case "lockedin":
+		return agenda.Since + int64(params.RuleChangeActivationInterval)
+	}
+	return -1
+}
+
+// LoadConsensusRules determines the treasury rules from the deployment status
+// reported by dcrd.
+func LoadConsensusRules(binfo *chainjson.GetBlockChainInfoResult, params *chaincfg.Params) ConsensusRules {
+	return ConsensusRules{
+		TreasuryHeight: AgendaHeight(binfo, params, chaincfg.VoteIDTreasury),
+		DCP0007Height:  AgendaHeight(binfo, params, chaincfg.VoteIDRevertTreasuryPolicy),
+	}
+}
+
+// At returns the name of the treasury rules in effect at the given height.
+func (r ConsensusRules) At(height int64) string {
+	switch {
+	case r.TreasuryHeight < 0 || height < r.TreasuryHeight:
+		return RulesPreTreasury
+	case r.DCP0007Height < 0 || height < r.DCP0007Height:
+		return RulesDCP0006
+	}
+	return RulesDCP0007
+}
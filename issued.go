@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/crypto/blake256"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/wire"
+)
+
+// issuedTSpend is a tspend generated for a payout set.
+type issuedTSpend struct {
+	TxHash    string    `json:"txhash"`
+	Expiry    uint32    `json:"expiry"`
+	CreatedAt time.Time `json:"createdat"`
+	Published bool      `json:"published"`
+	Tx        string    `json:"tx"`
+}
+
+// issuedState records the tspends generated for every payout set, so that the
+// same payouts are never paid by two conflicting tspends by accident.
+type issuedState struct {
+	Network    string                     `json:"network"`
+	PayoutSets map[string][]*issuedTSpend `json:"payoutsets"`
+
+	path string
+}
+
+// issuedStatePath returns the path of the issued tspends state of the given
+// network.
+func issuedStatePath(net chainNetwork) string {
+	return filepath.Join(defaultConfigDir, string(net)+"-issued.json")
+}
+
+// loadIssuedState loads the issued tspends state of the network of the config.
+// A missing file is an empty state.
+func loadIssuedState(cfg *config) (*issuedState, error) {
+	state := &issuedState{
+		Network:    cfg.chainParams.Name,
+		PayoutSets: make(map[string][]*issuedTSpend),
+		path:       issuedStatePath(cfg.activeNet),
+	}
+	b, err := os.ReadFile(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("unable to decode issued tspends state "+
+			"%s: %v", state.path, err)
+	}
+	if state.Network != cfg.chainParams.Name {
+		return nil, fmt.Errorf("issued tspends state %s is for network %s",
+			state.path, state.Network)
+	}
+	if state.PayoutSets == nil {
+		state.PayoutSets = make(map[string][]*issuedTSpend)
+	}
+	return state, nil
+}
+
+// save writes the state back to its file.
+func (state *issuedState) save() error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if err := os.WriteFile(state.path, b, 0600); err != nil {
+		return fmt.Errorf("unable to write issued tspends state: %v", err)
+	}
+	return nil
+}
+
+// payoutSetHash returns the hash that identifies a payout set regardless of
+// the order of its payouts: the blake256 hash of the (script version, script,
+// amount) of every payout, sorted.
+func payoutSetHash(payouts []*payout) string {
+	entries := make([][]byte, 0, len(payouts))
+	for _, p := range payouts {
+		version, script := p.address.PayFromTreasuryScript()
+		entry := make([]byte, 2+len(script)+8)
+		binary.BigEndian.PutUint16(entry, version)
+		copy(entry[2:], script)
+		binary.LittleEndian.PutUint64(entry[2+len(script):], uint64(p.amount))
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i], entries[j]) < 0
+	})
+
+	h := blake256.New()
+	h.Write([]byte("tspend payout set"))
+	var nb [4]byte
+	for _, entry := range entries {
+		binary.LittleEndian.PutUint32(nb[:], uint32(len(entry)))
+		h.Write(nb[:])
+		h.Write(entry)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// installmentSetHash returns the hash that identifies an installment of a
+// vesting schedule in the issued tspends state. Installments of a schedule
+// that splits evenly pay the same payout set, so the hash also commits to the
+// payouts of the whole schedule and to the position of the installment in it.
+func installmentSetHash(schedule, installment []*payout, index, count int) string {
+	h := blake256.New()
+	h.Write([]byte("tspend installment"))
+	var nb [4]byte
+	binary.LittleEndian.PutUint32(nb[:], uint32(index))
+	h.Write(nb[:])
+	binary.LittleEndian.PutUint32(nb[:], uint32(count))
+	h.Write(nb[:])
+	h.Write([]byte(payoutSetHash(schedule)))
+	h.Write([]byte(payoutSetHash(installment)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// latest returns the latest tspend issued for the payout set, if any.
+func (state *issuedState) latest(setHash string) *issuedTSpend {
+	issued := state.PayoutSets[setHash]
+	if len(issued) == 0 {
+		return nil
+	}
+	return issued[len(issued)-1]
+}
+
+// record records a tspend issued for the payout set. A tspend that was already
+// recorded is only updated.
+func (state *issuedState) record(setHash string, msgTx *wire.MsgTx, published bool) error {
+	rawTx, err := msgTx.Bytes()
+	if err != nil {
+		return err
+	}
+	txHash := msgTx.TxHash().String()
+	for _, prev := range state.PayoutSets[setHash] {
+		if prev.TxHash == txHash {
+			prev.Published = prev.Published || published
+			prev.Tx = hex.EncodeToString(rawTx)
+			return state.save()
+		}
+	}
+	state.PayoutSets[setHash] = append(state.PayoutSets[setHash], &issuedTSpend{
+		TxHash:    txHash,
+		Expiry:    msgTx.Expiry,
+		CreatedAt: time.Now().UTC(),
+		Published: published,
+		Tx:        hex.EncodeToString(rawTx),
+	})
+	return state.save()
+}
+
+// tspendStatus describes the status of an issued tspend, as far as dcrd (when
+// connected) knows about it.
+func tspendStatus(ctx context.Context, c *rpcclient.Client, issued *issuedTSpend) string {
+	published := "not published by this tool"
+	if issued.Published {
+		published = "published"
+	}
+	if c == nil {
+		return fmt.Sprintf("%s, unknown status (not connected to dcrd)",
+			published)
+	}
+	hash, err := chainhash.NewHashFromStr(issued.TxHash)
+	if err != nil {
+		return err.Error()
+	}
+	tx, err := c.GetRawTransactionVerbose(ctx, hash)
+	switch {
+	case err != nil:
+		_, height, err := c.GetBestBlock(ctx)
+		if err != nil {
+			return fmt.Sprintf("%s, unknown status", published)
+		}
+		if uint32(height) >= issued.Expiry {
+			return fmt.Sprintf("%s, not found in the mempool and "+
+				"expired at block %d", published, issued.Expiry)
+		}
+		return fmt.Sprintf("%s, not found in the mempool (expires at "+
+			"block %d)", published, issued.Expiry)
+	case tx.BlockHeight > 0:
+		return fmt.Sprintf("mined in block %d", tx.BlockHeight)
+	}
+	return "in the mempool"
+}
+
+// checkIssued checks whether a tspend was already issued for the payout set.
+// It returns the earlier tspend when it can be reproduced exactly (same expiry
+// and, if known, same tx hash) and an error explaining the earlier tspend and
+// its status when the new one would conflict with it, unless reissuing was
+// requested.
+func checkIssued(ctx context.Context, c *rpcclient.Client, cfg *config,
+	state *issuedState, setHash string, expiry uint32, txHash string) (*issuedTSpend, error) {
+
+	prev := state.latest(setHash)
+	if prev == nil || cfg.Reissue {
+		return nil, nil
+	}
+	if txHash == prev.TxHash || (txHash == "" && expiry == prev.Expiry) {
+		return prev, nil
+	}
+	return nil, fmt.Errorf("the same payouts were already issued in tspend "+
+		"%s (expiry %d, created at %s): %s; use --reissue to generate a "+
+		"new tspend for them anyway", prev.TxHash, prev.Expiry,
+		prev.CreatedAt.Format(time.RFC3339),
+		tspendStatus(ctx, c, prev))
+}
+
+// checkReproduced ensures the tspend previously issued for a payout set is the
+// one that would be generated with the current options, so that changes to the
+// fee rate or to the OP_RETURN data are not silently dropped when reproducing
+// it. Random OP_RETURN data cannot be reproduced, so it is only checked that
+// the earlier tspend did not use deterministic data.
+func checkReproduced(cfg *config, payouts []*payout, prev *issuedTSpend,
+	prevTx, msgTx *wire.MsgTx) error {
+
+	var diffs []string
+	prevFee, fee := txFee(prevTx), txFee(msgTx)
+	if prevFee != fee {
+		diffs = append(diffs, fmt.Sprintf("fee of %s instead of %s",
+			prevFee, fee))
+	}
+	sameOutputs := len(prevTx.TxOut) == len(msgTx.TxOut)
+	for i := 1; sameOutputs && i < len(msgTx.TxOut); i++ {
+		a, b := prevTx.TxOut[i], msgTx.TxOut[i]
+		sameOutputs = a.Value == b.Value && a.Version == b.Version &&
+			bytes.Equal(a.PkScript, b.PkScript)
+	}
+	if !sameOutputs {
+		diffs = append(diffs, "different payout outputs")
+	}
+	prevScript, script := prevTx.TxOut[0].PkScript, msgTx.TxOut[0].PkScript
+	if cfg.DeterministicOpReturn || cfg.CommitManifest != "" ||
+		cfg.OpReturnData != "" {
+		if !bytes.Equal(prevScript, script) {
+			diffs = append(diffs, fmt.Sprintf("OP_RETURN script %x "+
+				"instead of %x", prevScript, script))
+		}
+	} else {
+		detCfg := *cfg
+		detCfg.DeterministicOpReturn = true
+		detScript, err := loadOpReturnScript(&detCfg, payouts,
+			uint64(msgTx.TxIn[0].ValueIn))
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(prevScript, detScript) {
+			diffs = append(diffs, "deterministic OP_RETURN data "+
+				"instead of random data")
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the same payouts were already issued in tspend %s "+
+		"(expiry %d) with %s; use --reissue to generate a new tspend "+
+		"with the current options", prev.TxHash, prev.Expiry,
+		strings.Join(diffs, ", "))
+}
+
+// txFee returns the fee paid by a tspend: its input minus its outputs.
+func txFee(msgTx *wire.MsgTx) dcrutil.Amount {
+	fee := msgTx.TxIn[0].ValueIn
+	for _, txOut := range msgTx.TxOut {
+		fee -= txOut.Value
+	}
+	return dcrutil.Amount(fee)
+}
+
+// decodeIssued decodes the raw tx of an issued tspend.
+func decodeIssued(issued *issuedTSpend) (*wire.MsgTx, error) {
+	rawTx, err := hex.DecodeString(issued.Tx)
+	if err != nil {
+		return nil, err
+	}
+	var msgTx wire.MsgTx
+	if err := msgTx.FromBytes(rawTx); err != nil {
+		return nil, err
+	}
+	return &msgTx, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// TestEvenInstallmentsIssued ensures the installments of a schedule that
+// splits evenly are recorded as distinct entries of the issued tspends state
+// and are not reported as conflicting with each other.
+func TestEvenInstallmentsIssued(t *testing.T) {
+	params := chaincfg.SimNetParams()
+	cfg := &config{chainParams: params, FeeRate: int64(DefaultRelayFeePerKb)}
+	addr, err := parseStakeAddress("SsWKp7wtdTZYabYFYSc9cnxhwFEjA5g4pFc", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payouts := []*payout{{address: addr, amount: 100 * dcrutil.AtomsPerCoin}}
+
+	const n = 4
+	parts, err := splitInstallments(payouts, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range parts[1:] {
+		if payoutSetHash(part) != payoutSetHash(parts[0]) {
+			t.Fatal("installments of an even split have different " +
+				"payout sets")
+		}
+	}
+
+	state := &issuedState{
+		Network:    params.Name,
+		PayoutSets: make(map[string][]*issuedTSpend),
+		path:       filepath.Join(t.TempDir(), "issued.json"),
+	}
+	seen := make(map[string]int)
+	tvi := uint32(params.TreasuryVoteInterval)
+	for i, part := range parts {
+		setHash := installmentSetHash(payouts, part, i, n)
+		if j, ok := seen[setHash]; ok {
+			t.Fatalf("installments %d and %d have the same key", j, i)
+		}
+		seen[setHash] = i
+
+		expiry := tvi*uint32(10+i) + 2
+		msgTx, _, _, err := buildTSpend(cfg, part, expiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev, err := checkIssued(context.Background(), nil, cfg, state,
+			setHash, expiry, msgTx.TxHash().String())
+		if err != nil {
+			t.Fatalf("installment %d: %v", i, err)
+		}
+		if prev != nil {
+			t.Fatalf("installment %d reproduces tspend %s", i, prev.TxHash)
+		}
+		if err := state.record(setHash, msgTx, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(state.PayoutSets) != n {
+		t.Fatalf("got %d entries in the issued state, want %d",
+			len(state.PayoutSets), n)
+	}
+}
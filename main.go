@@ -17,7 +17,28 @@ func _main() error {
 
 	var mainErr error
 	go func() {
-		mainErr = genTspend(cfg, ctx)
+		switch cfg.command {
+		case "addrbook":
+			mainErr = runAddrBookCmd(cfg, cfg.args)
+		case "fingerprint":
+			mainErr = runFingerprintCmd(cfg, cfg.args)
+		case "inspect":
+			mainErr = runInspectCmd(cfg, cfg.args)
+		case "approve":
+			mainErr = runApproveCmd(cfg, cfg.args)
+		case "sign":
+			mainErr = runSignCmd(ctx, cfg, cfg.args)
+		case "reissue":
+			mainErr = runReissueCmd(ctx, cfg, cfg.args)
+		case "commitment":
+			mainErr = runCommitmentCmd(cfg, cfg.args)
+		case "verifycommitment":
+			mainErr = runVerifyCommitmentCmd(ctx, cfg, cfg.args)
+		case "opreturn":
+			mainErr = runOpReturnCmd(cfg, cfg.args)
+		default:
+			mainErr = genTspend(cfg, ctx)
+		}
 		requestShutdown()
 	}()
 
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/decred/dcrd/crypto/blake256"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/wire"
+)
+
+const (
+	// opReturnPayloadSize is the size of the data pushed by the OP_RETURN
+	// output of a tspend: the 8 byte total followed by 24 bytes of extra
+	// data.
+	opReturnPayloadSize = 32
+
+	// opReturnExtraSize is the size of the extra data of the OP_RETURN
+	// output of a tspend.
+	opReturnExtraSize = opReturnPayloadSize - 8
+
+	// maxDeterministicExtraSize is the maximum size of the user provided
+	// data hashed by the deterministic OP_RETURN scheme.
+	maxDeterministicExtraSize = 32
+
+	// deterministicOpReturnVersion is the version of the deterministic
+	// OP_RETURN scheme specified in docs/deterministic-opreturn.md.
+	deterministicOpReturnVersion = 1
+)
+
+// decodeOpReturnData decodes hex encoded OP_RETURN data, which must have at
+// most maxSize bytes.
+func decodeOpReturnData(s string, maxSize int) ([]byte, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode OP_RETURN data: %v", err)
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("OP_RETURN data has %d bytes, more than "+
+			"the maximum of %d bytes", len(data), maxSize)
+	}
+	return data, nil
+}
+
+// deterministicOpReturnData returns the extra OP_RETURN data of the
+// deterministic scheme (version 1): the first 24 bytes of
+//
+//	blake256("tspend OP_RETURN" || for each payout, in output order:
+//	    amount (8 bytes, LE) || script version (2 bytes, BE) || script
+//	    || extra)
+//
+// where script is the OP_TGEN script paying the address of the payout.
+func deterministicOpReturnData(payouts []*payout, extra []byte) []byte {
+	h := blake256.New()
+	h.Write([]byte("tspend OP_RETURN"))
+	var ab [8]byte
+	var vb [2]byte
+	for _, p := range payouts {
+		version, script := p.address.PayFromTreasuryScript()
+		binary.LittleEndian.PutUint64(ab[:], uint64(p.amount))
+		binary.BigEndian.PutUint16(vb[:], version)
+		h.Write(ab[:])
+		h.Write(vb[:])
+		h.Write(script)
+	}
+	h.Write(extra)
+	return h.Sum(nil)[:opReturnExtraSize]
+}
+
+// opReturnScript returns the OP_RETURN script of a tspend with the given total
+// and extra data. Extra data shorter than 24 bytes is padded with zeros and
+// longer extra data is rejected.
+func opReturnScript(total uint64, extra []byte) ([]byte, error) {
+	if len(extra) > opReturnExtraSize {
+		return nil, fmt.Errorf("OP_RETURN extra data has %d bytes, more "+
+			"than the maximum of %d bytes", len(extra), opReturnExtraSize)
+	}
+	payload := make([]byte, opReturnPayloadSize)
+	binary.LittleEndian.PutUint64(payload, total)
+	copy(payload[8:], extra)
+	builder := txscript.NewScriptBuilder()
+	builder.AddOp(txscript.OP_RETURN)
+	builder.AddData(payload)
+	return builder.Script()
+}
+
+// opReturnPayload returns the data pushed by the OP_RETURN output of a tspend.
+func opReturnPayload(msgTx *wire.MsgTx) ([]byte, error) {
+	if len(msgTx.TxOut) == 0 {
+		return nil, fmt.Errorf("tx has no outputs")
+	}
+	script := msgTx.TxOut[0].PkScript
+	if len(script) != 2+opReturnPayloadSize || script[0] != txscript.OP_RETURN ||
+		script[1] != txscript.OP_DATA_32 {
+		return nil, fmt.Errorf("first output is not an OP_RETURN with %d "+
+			"bytes of data", opReturnPayloadSize)
+	}
+	return script[2:], nil
+}
+
+// opReturnVectors is a file of test vectors of the deterministic OP_RETURN
+// scheme.
+type opReturnVectors struct {
+	Version int `json:"version"`
+	Vectors []struct {
+		Description string `json:"description"`
+		Network     string `json:"network"`
+		Payouts     []struct {
+			Address string `json:"address"`
+			Amount  int64  `json:"amount"`
+		} `json:"payouts"`
+		Total     uint64 `json:"total"`
+		ExtraData string `json:"extradata"`
+		OpReturn  string `json:"opreturn"`
+	} `json:"vectors"`
+}
+
+// checkOpReturnVectors checks that the deterministic OP_RETURN scripts
+// computed for every vector of the file match the expected ones.
+func checkOpReturnVectors(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var vectors opReturnVectors
+	if err := json.Unmarshal(b, &vectors); err != nil {
+		return fmt.Errorf("unable to decode test vectors: %v", err)
+	}
+	if vectors.Version != deterministicOpReturnVersion {
+		return fmt.Errorf("unsupported deterministic OP_RETURN version %d",
+			vectors.Version)
+	}
+
+	var failed int
+	for i, v := range vectors.Vectors {
+		net := chainNetwork(v.Network)
+		switch net {
+		case cnMainNet, cnTestNet, cnSimNet:
+		default:
+			return fmt.Errorf("vector %d: unknown network %q", i, v.Network)
+		}
+		payouts := make([]*payout, 0, len(v.Payouts))
+		for j, p := range v.Payouts {
+			addr, err := parseStakeAddress(p.Address, net.chainParams())
+			if err != nil {
+				return fmt.Errorf("vector %d payout %d: %v", i, j, err)
+			}
+			payouts = append(payouts, &payout{
+				address: addr,
+				amount:  dcrutil.Amount(p.Amount),
+			})
+		}
+		extra, err := decodeOpReturnData(v.ExtraData, maxDeterministicExtraSize)
+		if err != nil {
+			return fmt.Errorf("vector %d: %v", i, err)
+		}
+		want, err := hex.DecodeString(v.OpReturn)
+		if err != nil {
+			return fmt.Errorf("vector %d: %v", i, err)
+		}
+		script, err := opReturnScript(v.Total,
+			deterministicOpReturnData(payouts, extra))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(script, want) {
+			log.Errorf("Vector %d (%s): expected OP_RETURN %x, got %x", i,
+				v.Description, want, script)
+			failed++
+			continue
+		}
+		fmt.Printf("Vector %d (%s): ok\n", i, v.Description)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d test vectors failed", failed,
+			len(vectors.Vectors))
+	}
+	return nil
+}
+
+// checkDeterministicTSpends checks that the OP_RETURN of every tspend in the
+// given file (or stdin for "-") is the deterministic one for its payouts and
+// the given extra data.
+func checkDeterministicTSpends(cfg *config, path string, extraHex string) error {
+	extra, err := decodeOpReturnData(extraHex, maxDeterministicExtraSize)
+	if err != nil {
+		return err
+	}
+	msgTxs, err := readTSpends(path)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, msgTx := range msgTxs {
+		txHash := msgTx.TxHash()
+		payouts, err := tspendPayouts(msgTx, cfg.chainParams)
+		if err != nil {
+			return err
+		}
+		payload, err := opReturnPayload(msgTx)
+		if err != nil {
+			return fmt.Errorf("tspend %s: %v", txHash, err)
+		}
+		total := binary.LittleEndian.Uint64(payload)
+		if int64(total) != msgTx.TxIn[0].ValueIn {
+			log.Errorf("TSpend %s: OP_RETURN total %d does not match the "+
+				"value in %d", txHash, total, msgTx.TxIn[0].ValueIn)
+			failed++
+			continue
+		}
+		want := deterministicOpReturnData(payouts, extra)
+		if !bytes.Equal(payload[8:], want) {
+			log.Errorf("TSpend %s: OP_RETURN data %x is not the "+
+				"deterministic %x", txHash, payload[8:], want)
+			failed++
+			continue
+		}
+		fmt.Printf("TSpend %s: deterministic OP_RETURN (version %d)\n",
+			txHash, deterministicOpReturnVersion)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tspends do not have a deterministic "+
+			"OP_RETURN", failed, len(msgTxs))
+	}
+	return nil
+}
+
+// runOpReturnCmd runs the opreturn command, which verifies deterministic
+// OP_RETURN scripts, either of tspends or of a test vectors file.
+func runOpReturnCmd(cfg *config, args []string) error {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	switch {
+	case args[0] == "vectors" && len(args) == 2:
+		return checkOpReturnVectors(args[1])
+	case args[0] == "verify" && len(args) == 2:
+		return checkDeterministicTSpends(cfg, args[1], "")
+	case args[0] == "verify" && len(args) == 3:
+		return checkDeterministicTSpends(cfg, args[1], args[2])
+	}
+	return fmt.Errorf("usage: opreturn [vectors <file> | verify <file|-> " +
+		"[<extra data>]]")
+}
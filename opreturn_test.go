@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/crypto/blake256"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/wire"
+)
+
+// opReturnVectorsFile is the file with the test vectors of the deterministic
+// OP_RETURN scheme.
+const opReturnVectorsFile = "docs/deterministic-opreturn-vectors.json"
+
+// TestOpReturnVectors ensures the OP_RETURN scripts of the test vectors are
+// encoded as expected and that their total and extra data decode back.
+func TestOpReturnVectors(t *testing.T) {
+	b, err := os.ReadFile(opReturnVectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors opReturnVectors
+	if err := json.Unmarshal(b, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if vectors.Version != deterministicOpReturnVersion {
+		t.Fatalf("got vectors version %d, want %d", vectors.Version,
+			deterministicOpReturnVersion)
+	}
+	if len(vectors.Vectors) == 0 {
+		t.Fatal("no test vectors")
+	}
+
+	for i, v := range vectors.Vectors {
+		net := chainNetwork(v.Network)
+		switch net {
+		case cnMainNet, cnTestNet, cnSimNet:
+		default:
+			t.Fatalf("vector %d: unknown network %q", i, v.Network)
+		}
+		params := net.chainParams()
+		payouts := make([]*payout, 0, len(v.Payouts))
+		for _, p := range v.Payouts {
+			addr, err := parseStakeAddress(p.Address, params)
+			if err != nil {
+				t.Fatalf("vector %d: %v", i, err)
+			}
+			payouts = append(payouts, &payout{
+				address: addr,
+				amount:  dcrutil.Amount(p.Amount),
+			})
+		}
+		extra, err := decodeOpReturnData(v.ExtraData,
+			maxDeterministicExtraSize)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		want, err := hex.DecodeString(v.OpReturn)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+
+		// Encode.
+		data := deterministicOpReturnData(payouts, extra)
+		script, err := opReturnScript(v.Total, data)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if !bytes.Equal(script, want) {
+			t.Fatalf("vector %d (%s): got OP_RETURN %x, want %x", i,
+				v.Description, script, want)
+		}
+
+		// Decode.
+		msgTx := wire.NewMsgTx()
+		msgTx.AddTxOut(wire.NewTxOut(0, want))
+		payload, err := opReturnPayload(msgTx)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if total := binary.LittleEndian.Uint64(payload); total != v.Total {
+			t.Fatalf("vector %d: got total %d, want %d", i, total,
+				v.Total)
+		}
+		if !bytes.Equal(payload[8:], data) {
+			t.Fatalf("vector %d: got extra data %x, want %x", i,
+				payload[8:], data)
+		}
+	}
+	if err := checkOpReturnVectors(opReturnVectorsFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpReturnHandVector checks the first test vector against an OP_RETURN
+// script assembled byte by byte from docs/deterministic-opreturn.md, without
+// using the encoder.
+func TestOpReturnHandVector(t *testing.T) {
+	// Mainnet P2PKH address with a hash160 of twenty 0x11 bytes, paid 1 DCR
+	// (100000000 atoms) with a fee of 2550 atoms.
+	const address = "DsSX9RWD3yfGke8BAoXxMVbPURSYbzaeyWU"
+	const total = 100002550
+
+	// "tspend OP_RETURN" || amount (LE) || script version (BE) ||
+	// OP_TGEN OP_DUP OP_HASH160 OP_DATA_20 <hash> OP_EQUALVERIFY
+	// OP_CHECKSIG, without user data.
+	preimage := []byte("tspend OP_RETURN")
+	preimage = append(preimage, 0x00, 0xe1, 0xf5, 0x05, 0, 0, 0, 0)
+	preimage = append(preimage, 0x00, 0x00)
+	preimage = append(preimage, 0xc3, 0x76, 0xa9, 0x14)
+	preimage = append(preimage, bytes.Repeat([]byte{0x11}, 20)...)
+	preimage = append(preimage, 0x88, 0xac)
+	hash := blake256.Sum256(preimage)
+
+	// OP_RETURN OP_DATA_32 || total (LE) || first 24 bytes of the hash.
+	want := []byte{0x6a, 0x20, 0xf6, 0xea, 0xf5, 0x05, 0, 0, 0, 0}
+	want = append(want, hash[:24]...)
+
+	const vector = "6a20f6eaf50500000000cac73f3778e329b9f314626004070bee" +
+		"409c4cd364db1744"
+	if got := hex.EncodeToString(want); got != vector {
+		t.Fatalf("hand computed OP_RETURN %s does not match the test "+
+			"vector %s", got, vector)
+	}
+
+	addr, err := parseStakeAddress(address, chaincfg.MainNetParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	payouts := []*payout{{address: addr, amount: 1e8}}
+	script, err := opReturnScript(total, deterministicOpReturnData(payouts, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(script, want) {
+		t.Fatalf("got OP_RETURN %x, want %x", script, want)
+	}
+}
+
+// TestOpReturnLengthLimits ensures OP_RETURN data over the length limits is
+// rejected and data at the limits is accepted.
+func TestOpReturnLengthLimits(t *testing.T) {
+	hexBytes := func(n int) string {
+		return strings.Repeat("ab", n)
+	}
+	tests := []struct {
+		name    string
+		data    string
+		maxSize int
+		valid   bool
+	}{
+		{"empty", "", opReturnExtraSize, true},
+		{"24 bytes of extra data", hexBytes(24), opReturnExtraSize, true},
+		{"25 bytes of extra data", hexBytes(25), opReturnExtraSize, false},
+		{"32 bytes of user data", hexBytes(32), maxDeterministicExtraSize, true},
+		{"33 bytes of user data", hexBytes(33), maxDeterministicExtraSize, false},
+		{"odd length", "abc", opReturnExtraSize, false},
+		{"not hex", "zz", opReturnExtraSize, false},
+	}
+	for _, test := range tests {
+		_, err := decodeOpReturnData(test.data, test.maxSize)
+		if (err == nil) != test.valid {
+			t.Fatalf("%s: got error %v, want valid %v", test.name, err,
+				test.valid)
+		}
+	}
+
+	// The configured OP_RETURN data goes through the same limits.
+	params := chaincfg.SimNetParams()
+	addr, err := parseStakeAddress("SsWKp7wtdTZYabYFYSc9cnxhwFEjA5g4pFc", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payouts := []*payout{{address: addr, amount: 1e8}}
+	cfgTests := []struct {
+		name          string
+		data          string
+		deterministic bool
+		valid         bool
+	}{
+		{"24 bytes", hexBytes(24), false, true},
+		{"25 bytes", hexBytes(25), false, false},
+		{"32 bytes deterministic", hexBytes(32), true, true},
+		{"33 bytes deterministic", hexBytes(33), true, false},
+	}
+	for _, test := range cfgTests {
+		cfg := &config{
+			chainParams:           params,
+			OpReturnData:          test.data,
+			DeterministicOpReturn: test.deterministic,
+		}
+		_, err := loadOpReturnScript(cfg, payouts, 1e8)
+		if (err == nil) != test.valid {
+			t.Fatalf("%s: got error %v, want valid %v", test.name, err,
+				test.valid)
+		}
+	}
+
+	// The encoded extra data is at most 24 bytes.
+	if _, err := opReturnScript(1, make([]byte, opReturnExtraSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opReturnScript(1, make([]byte, opReturnExtraSize+1)); err == nil {
+		t.Fatal("25 bytes of extra data were encoded")
+	}
+
+	// Only OP_RETURN scripts with exactly 32 bytes of data are decoded.
+	scripts := map[string][]byte{
+		"31 bytes of data": append([]byte{txscript.OP_RETURN,
+			txscript.OP_DATA_31}, make([]byte, 31)...),
+		"33 bytes of data": append([]byte{txscript.OP_RETURN,
+			txscript.OP_DATA_33}, make([]byte, 33)...),
+		"no OP_RETURN": append([]byte{txscript.OP_NOP,
+			txscript.OP_DATA_32}, make([]byte, 32)...),
+	}
+	for name, script := range scripts {
+		msgTx := wire.NewMsgTx()
+		msgTx.AddTxOut(wire.NewTxOut(0, script))
+		if _, err := opReturnPayload(msgTx); err == nil {
+			t.Fatalf("%s: payload decoded", name)
+		}
+	}
+	if _, err := opReturnPayload(wire.NewMsgTx()); err == nil {
+		t.Fatal("payload of a tx without outputs decoded")
+	}
+}
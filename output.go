@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpc/jsonrpc/types/v4"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/txscript/v4/stdscript"
+	"github.com/decred/dcrd/wire"
+)
+
+// outputFormats are the supported formats of the resulting tspends.
+var outputFormats = []string{"hex", "binary", "base64", "json"}
+
+// outputSpec is a format in which to write the resulting tspends and where to
+// write them. An empty path is stdout.
+type outputSpec struct {
+	format string
+	path   string
+}
+
+// parseOutputSpecs parses the --format options, each a format optionally
+// followed by ":<file>". Only the first ':' after a known format name
+// separates it from the file, so file names may contain ':' (e.g.
+// "json:./a:b"). Formats without a file are written to the --out file (or
+// stdout). Without any --format, the tspends are written in hex.
+func parseOutputSpecs(formats []string, out string) ([]outputSpec, error) {
+	if len(formats) == 0 {
+		formats = []string{"hex"}
+	}
+	specs := make([]outputSpec, 0, len(formats))
+	dests := make(map[string]string, len(formats))
+	for _, s := range formats {
+		var format, path string
+		for _, f := range outputFormats {
+			switch {
+			case s == f:
+				format, path = f, out
+			case strings.HasPrefix(s, f+":"):
+				format, path = f, s[len(f)+1:]
+				if path == "" {
+					return nil, fmt.Errorf("empty output file in "+
+						"format %q", s)
+				}
+			}
+		}
+		if format == "" {
+			return nil, fmt.Errorf("unknown output format %q (supported: "+
+				"%s, optionally followed by :<file>)", s,
+				strings.Join(outputFormats, ", "))
+		}
+		dest := path
+		if dest == "" {
+			dest = "stdout"
+		}
+		if prev, ok := dests[dest]; ok {
+			return nil, fmt.Errorf("formats %s and %s cannot both be "+
+				"written to %s", prev, format, dest)
+		}
+		dests[dest] = format
+		specs = append(specs, outputSpec{format: format, path: path})
+	}
+	return specs, nil
+}
+
+// tspendJSONPayout is the total paid by a tspend to an address.
+type tspendJSONPayout struct {
+	Address     string   `json:"address"`
+	Name        string   `json:"name,omitempty"`
+	Fingerprint string   `json:"fingerprint"`
+	Amount      float64  `json:"amount"`
+	Outputs     []uint32 `json:"outputs"`
+
+	atoms dcrutil.Amount
+}
+
+// tspendJSONInfo holds the tspend specific fields of the JSON output.
+type tspendJSONInfo struct {
+	SignerPubKey string             `json:"signerpubkey"`
+	PiKey        bool               `json:"pikey"`
+	WindowStart  uint32             `json:"windowstart"`
+	WindowEnd    uint32             `json:"windowend"`
+	Total        float64            `json:"total"`
+	Fee          float64            `json:"fee"`
+	OpReturnData string             `json:"opreturndata"`
+	Payouts      []tspendJSONPayout `json:"payouts"`
+}
+
+// tspendJSON is the JSON output of a tspend: the result of dcrd's
+// decoderawtransaction enriched with tspend specific fields.
+type tspendJSON struct {
+	types.TxRawDecodeResult
+	Hex    string         `json:"hex"`
+	TSpend tspendJSONInfo `json:"tspend"`
+}
+
+// newTSpendJSON returns the JSON output of a signed tspend. Addresses found in
+// the address book are named.
+func newTSpendJSON(cfg *config, msgTx *wire.MsgTx, names map[string]string) (*tspendJSON, error) {
+	params := cfg.chainParams
+	rawTx, err := msgTx.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	_, pubKey, err := stake.CheckTSpend(msgTx)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := blockchain.CalcTSpendWindow(msgTx.Expiry,
+		params.TreasuryVoteInterval, params.TreasuryVoteIntervalMultiplier)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := opReturnPayload(msgTx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &tspendJSON{
+		TxRawDecodeResult: types.TxRawDecodeResult{
+			Txid:     msgTx.TxHash().String(),
+			Version:  int32(msgTx.Version),
+			Locktime: msgTx.LockTime,
+			Expiry:   msgTx.Expiry,
+		},
+		Hex: hex.EncodeToString(rawTx),
+		TSpend: tspendJSONInfo{
+			SignerPubKey: hex.EncodeToString(pubKey),
+			PiKey:        isPiKey(params, pubKey),
+			WindowStart:  start,
+			WindowEnd:    end,
+			OpReturnData: hex.EncodeToString(payload[8:]),
+		},
+	}
+	txIn := msgTx.TxIn[0]
+	sigAsm, _ := txscript.DisasmString(txIn.SignatureScript)
+	res.Vin = []types.Vin{{
+		TreasurySpend: hex.EncodeToString(txIn.SignatureScript),
+		Sequence:      txIn.Sequence,
+		AmountIn:      dcrutil.Amount(txIn.ValueIn).ToCoin(),
+		BlockHeight:   txIn.BlockHeight,
+		BlockIndex:    txIn.BlockIndex,
+		ScriptSig: &types.ScriptSig{
+			Asm: sigAsm,
+			Hex: hex.EncodeToString(txIn.SignatureScript),
+		},
+	}}
+
+	var total dcrutil.Amount
+	var order []string
+	byAddr := make(map[string]*tspendJSONPayout)
+	for i, txOut := range msgTx.TxOut {
+		asm, _ := txscript.DisasmString(txOut.PkScript)
+		scriptType := stdscript.DetermineScriptType(txOut.Version,
+			txOut.PkScript)
+		vout := types.Vout{
+			Value:   dcrutil.Amount(txOut.Value).ToCoin(),
+			N:       uint32(i),
+			Version: txOut.Version,
+			ScriptPubKey: types.ScriptPubKeyResult{
+				Asm:     asm,
+				Hex:     hex.EncodeToString(txOut.PkScript),
+				Type:    scriptType.String(),
+				Version: txOut.Version,
+			},
+		}
+		if i > 0 {
+			_, addrs := stdscript.ExtractAddrsV0(txOut.PkScript[1:], params)
+			for _, addr := range addrs {
+				vout.ScriptPubKey.Addresses = append(
+					vout.ScriptPubKey.Addresses, addr.String())
+			}
+			vout.ScriptPubKey.ReqSigs = int32(len(addrs))
+			if len(addrs) == 1 {
+				s := addrs[0].String()
+				p, ok := byAddr[s]
+				if !ok {
+					p = &tspendJSONPayout{
+						Address:     s,
+						Name:        names[s],
+						Fingerprint: addrFingerprint(addrs[0]),
+					}
+					byAddr[s] = p
+					order = append(order, s)
+				}
+				p.atoms += dcrutil.Amount(txOut.Value)
+				p.Outputs = append(p.Outputs, uint32(i))
+			}
+			total += dcrutil.Amount(txOut.Value)
+		}
+		res.Vout = append(res.Vout, vout)
+	}
+	for _, s := range order {
+		p := byAddr[s]
+		p.Amount = p.atoms.ToCoin()
+		res.TSpend.Payouts = append(res.TSpend.Payouts, *p)
+	}
+	res.TSpend.Total = total.ToCoin()
+	res.TSpend.Fee = (dcrutil.Amount(txIn.ValueIn) - total).ToCoin()
+	return res, nil
+}
+
+// writeTSpendsFormat writes the tspends to w in the given format. Text formats
+// write one tspend per line, binary concatenates the serialized tspends and
+// json writes an array with one document per tspend.
+func writeTSpendsFormat(cfg *config, w io.Writer, format string, msgTxs []*wire.MsgTx) error {
+	if format == "json" {
+		book, err := loadAddrBook(cfg.AddrBook, cfg.chainParams)
+		if err != nil {
+			return err
+		}
+		names := make(map[string]string, len(book.Payees))
+		for name, addr := range book.Payees {
+			names[addr] = name
+		}
+		docs := make([]*tspendJSON, 0, len(msgTxs))
+		for _, msgTx := range msgTxs {
+			doc, err := newTSpendJSON(cfg, msgTx, names)
+			if err != nil {
+				return fmt.Errorf("unable to encode tspend %s: %v",
+					msgTx.TxHash(), err)
+			}
+			docs = append(docs, doc)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(docs)
+	}
+
+	for _, msgTx := range msgTxs {
+		rawTx, err := msgTx.Bytes()
+		if err != nil {
+			return err
+		}
+		switch format {
+		case "hex":
+			_, err = fmt.Fprintf(w, "%x\n", rawTx)
+		case "base64":
+			_, err = fmt.Fprintln(w, base64.StdEncoding.EncodeToString(rawTx))
+		case "binary":
+			_, err = w.Write(rawTx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTSpends writes the tspends in every configured output format.
+func writeTSpends(cfg *config, msgTxs ...*wire.MsgTx) error {
+	for _, spec := range cfg.outputs {
+		if spec.path == "" {
+			err := writeTSpendsFormat(cfg, os.Stdout, spec.format, msgTxs)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		f, err := os.Create(spec.path)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %v", err)
+		}
+		err = writeTSpendsFormat(cfg, f, spec.format, msgTxs)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseOutputSpecs ensures output formats are split from their files on
+// the first ':' after a known format name and that unknown formats are
+// rejected.
+func TestParseOutputSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		formats []string
+		out     string
+		want    []outputSpec
+		valid   bool
+	}{{
+		name:  "default",
+		want:  []outputSpec{{format: "hex"}},
+		valid: true,
+	}, {
+		name:    "format to --out",
+		formats: []string{"base64"},
+		out:     "tspend.b64",
+		want:    []outputSpec{{format: "base64", path: "tspend.b64"}},
+		valid:   true,
+	}, {
+		name:    "several formats",
+		formats: []string{"hex", "binary:tspend.bin", "json:tspend.json"},
+		want: []outputSpec{
+			{format: "hex"},
+			{format: "binary", path: "tspend.bin"},
+			{format: "json", path: "tspend.json"},
+		},
+		valid: true,
+	}, {
+		name:    "file with colons",
+		formats: []string{"json:./a:b"},
+		want:    []outputSpec{{format: "json", path: "./a:b"}},
+		valid:   true,
+	}, {
+		name:    "windows path",
+		formats: []string{`binary:C:\out.bin`},
+		want:    []outputSpec{{format: "binary", path: `C:\out.bin`}},
+		valid:   true,
+	}, {
+		name:    "windows path without format",
+		formats: []string{`C:\out.bin`},
+	}, {
+		name:    "unknown format",
+		formats: []string{"yaml"},
+	}, {
+		name:    "unknown format with file",
+		formats: []string{"yaml:tspend.yaml"},
+	}, {
+		name:    "known format prefix",
+		formats: []string{"jsonx:tspend.json"},
+	}, {
+		name:    "empty file",
+		formats: []string{"hex:"},
+	}, {
+		name:    "same destination",
+		formats: []string{"hex", "base64"},
+	}}
+	for _, test := range tests {
+		specs, err := parseOutputSpecs(test.formats, test.out)
+		if (err == nil) != test.valid {
+			t.Fatalf("%s: got error %v, want valid %v", test.name, err,
+				test.valid)
+		}
+		if test.valid && !reflect.DeepEqual(specs, test.want) {
+			t.Fatalf("%s: got %+v, want %+v", test.name, specs,
+				test.want)
+		}
+	}
+}
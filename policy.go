@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+)
+
+// signingPolicy is the set of organization-level rules that every tspend must
+// follow before it is signed. Rules that are not set are not enforced.
+type signingPolicy struct {
+	Network string `json:"network"`
+
+	// MaxTotal and MaxPerRecipient are amounts in DCR (or atoms, with an
+	// "atoms" suffix) that limit the sum of the payouts of a tspend and the
+	// sum of the payouts of a tspend to a single address.
+	MaxTotal        string `json:"maxtotal"`
+	MaxPerRecipient string `json:"maxperrecipient"`
+
+	// Allowed lists the only addresses that may receive payouts, when not
+	// empty. Blocked lists addresses that may never receive payouts.
+	Allowed []string `json:"allowed"`
+	Blocked []string `json:"blocked"`
+
+	// MinLeadBlocks is the minimum number of blocks between the current
+	// height and the start of the voting interval of a tspend.
+	MinLeadBlocks int64 `json:"minleadblocks"`
+
+	// MaxOutputs is the maximum number of payouts of a tspend.
+	MaxOutputs int `json:"maxoutputs"`
+
+	// RequiredMeta lists the metadata fields (--meta key=value) that must
+	// be given to generate a tspend.
+	RequiredMeta []string `json:"requiredmeta"`
+
+	path            string
+	maxTotal        dcrutil.Amount
+	maxPerRecipient dcrutil.Amount
+	allowed         map[string]struct{}
+	blocked         map[string]struct{}
+}
+
+// policyPath returns the path of the signing policy of the given network. The
+// path is fixed so that the policy cannot be bypassed from the command line.
+func policyPath(net chainNetwork) string {
+	return filepath.Join(defaultConfigDir, string(net)+"-policy.json")
+}
+
+// loadSigningPolicy loads and validates the signing policy at the given path.
+// It returns nil if there is no policy file.
+func loadSigningPolicy(path string, chainParams *chaincfg.Params) (*signingPolicy, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy := &signingPolicy{path: path}
+	if err := json.Unmarshal(b, policy); err != nil {
+		return nil, fmt.Errorf("unable to decode signing policy %s: %v",
+			path, err)
+	}
+	if policy.Network != chainParams.Name {
+		return nil, fmt.Errorf("signing policy %s is for network %s",
+			path, policy.Network)
+	}
+
+	if policy.MaxTotal != "" {
+		if policy.maxTotal, err = parseTotal(policy.MaxTotal); err != nil {
+			return nil, fmt.Errorf("signing policy %s: maxtotal: %v",
+				path, err)
+		}
+	}
+	if policy.MaxPerRecipient != "" {
+		policy.maxPerRecipient, err = parseTotal(policy.MaxPerRecipient)
+		if err != nil {
+			return nil, fmt.Errorf("signing policy %s: "+
+				"maxperrecipient: %v", path, err)
+		}
+	}
+	if policy.MinLeadBlocks < 0 || policy.MaxOutputs < 0 {
+		return nil, fmt.Errorf("signing policy %s: limits cannot be "+
+			"negative", path)
+	}
+
+	addrSet := func(list []string) (map[string]struct{}, error) {
+		set := make(map[string]struct{}, len(list))
+		for _, s := range list {
+			addr, err := parseStakeAddress(s, chainParams)
+			if err != nil {
+				return nil, fmt.Errorf("signing policy %s: address "+
+					"%s: %v", path, s, err)
+			}
+			set[addr.String()] = struct{}{}
+		}
+		return set, nil
+	}
+	if policy.allowed, err = addrSet(policy.Allowed); err != nil {
+		return nil, err
+	}
+	if policy.blocked, err = addrSet(policy.Blocked); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// parseMeta parses the metadata fields given as key=value pairs.
+func parseMeta(pairs []string) (map[string]string, error) {
+	meta := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid metadata %q: must be "+
+				"key=value", pair)
+		}
+		key := strings.TrimSpace(pair[:i])
+		if _, ok := meta[key]; ok {
+			return nil, fmt.Errorf("metadata field %s specified more "+
+				"than once", key)
+		}
+		meta[key] = strings.TrimSpace(pair[i+1:])
+	}
+	return meta, nil
+}
+
+// logMeta logs the metadata fields, sorted by key.
+func logMeta(meta map[string]string) {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		log.Infof("Metadata %s: %s", key, meta[key])
+	}
+}
+
+// currentHeight returns the current height of the chain, either as configured
+// or as reported by dcrd.
+func currentHeight(ctx context.Context, cfg *config, c *rpcclient.Client) (int64, error) {
+	if cfg.CurrentHeight != 0 {
+		return int64(cfg.CurrentHeight), nil
+	}
+	_, height, err := c.GetBestBlock(ctx)
+	return height, err
+}
+
+// check returns every rule of the policy violated by a tspend with the given
+// payouts and expiry, given the current height of the chain.
+func (policy *signingPolicy) check(cfg *config, payouts []*payout,
+	expiry uint32, height int64) []string {
+
+	var violations []string
+	violatef := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	if policy.MaxOutputs > 0 && len(payouts) > policy.MaxOutputs {
+		violatef("maxoutputs: %d payouts exceed the maximum of %d",
+			len(payouts), policy.MaxOutputs)
+	}
+
+	var total dcrutil.Amount
+	perRecipient := make(map[string]dcrutil.Amount)
+	var recipients []string
+	for _, p := range payouts {
+		addr := p.address.String()
+		total += p.amount
+		if _, ok := perRecipient[addr]; !ok {
+			recipients = append(recipients, addr)
+		}
+		perRecipient[addr] += p.amount
+	}
+	if policy.maxTotal > 0 && total > policy.maxTotal {
+		violatef("maxtotal: total of %s exceeds the maximum of %s",
+			total, policy.maxTotal)
+	}
+	for _, addr := range recipients {
+		amount := perRecipient[addr]
+		if policy.maxPerRecipient > 0 && amount > policy.maxPerRecipient {
+			violatef("maxperrecipient: %s to %s exceeds the maximum "+
+				"of %s", amount, addr, policy.maxPerRecipient)
+		}
+		if _, ok := policy.blocked[addr]; ok {
+			violatef("blocked: %s is blocked", addr)
+		}
+		if _, ok := policy.allowed[addr]; len(policy.allowed) > 0 && !ok {
+			violatef("allowed: %s is not in the allowed list", addr)
+		}
+	}
+
+	if policy.MinLeadBlocks > 0 {
+		tvi := cfg.chainParams.TreasuryVoteInterval
+		mul := cfg.chainParams.TreasuryVoteIntervalMultiplier
+		start, _, err := blockchain.CalcTSpendWindow(expiry, tvi, mul)
+		switch {
+		case err != nil:
+			violatef("minleadblocks: invalid expiry %d: %v", expiry, err)
+		case int64(start)-height < policy.MinLeadBlocks:
+			violatef("minleadblocks: voting starts at block %d, %d "+
+				"blocks from the current height %d (minimum %d)",
+				start, int64(start)-height, height,
+				policy.MinLeadBlocks)
+		}
+	}
+
+	for _, key := range policy.RequiredMeta {
+		if cfg.meta[key] == "" {
+			violatef("requiredmeta: metadata field %s is missing "+
+				"(--meta %s=...)", key, key)
+		}
+	}
+	return violations
+}
+
+// enforceSigningPolicy checks the tspends (given by their payouts and
+// expiries) against the signing policy, if any, and reports every violated
+// rule. There is no way to override a violation other than editing the
+// policy. The current height for minleadblocks is always fetched from dcrd.
+func enforceSigningPolicy(ctx context.Context, c *rpcclient.Client,
+	cfg *config, payouts [][]*payout, expiries []uint32) error {
+
+	policy := cfg.policy
+	if policy == nil {
+		return nil
+	}
+
+	// The lead time is always measured from the tip reported by dcrd, so
+	// that it cannot be bypassed with --currentheight.
+	var height int64
+	if policy.MinLeadBlocks > 0 {
+		var err error
+		if _, height, err = c.GetBestBlock(ctx); err != nil {
+			return err
+		}
+		if cfg.CurrentHeight != 0 && int64(cfg.CurrentHeight) != height {
+			log.Warnf("Ignoring --currentheight %d for the "+
+				"minleadblocks policy: using the current dcrd "+
+				"height %d", cfg.CurrentHeight, height)
+		}
+	}
+
+	var nbViolations int
+	for i := range payouts {
+		violations := policy.check(cfg, payouts[i], expiries[i], height)
+		for _, v := range violations {
+			if len(payouts) > 1 {
+				log.Errorf("Policy violation (tspend %d): %s", i+1, v)
+			} else {
+				log.Errorf("Policy violation: %s", v)
+			}
+		}
+		nbViolations += len(violations)
+	}
+	if nbViolations > 0 {
+		return fmt.Errorf("refusing to sign due to the violations of "+
+			"the signing policy %s listed above", policy.path)
+	}
+	log.Infof("TSpend complies with the signing policy %s", policy.path)
+	return nil
+}
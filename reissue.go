@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/wire"
+)
+
+// txHashRE matches a tx hash.
+var txHashRE = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// loadOriginalTSpend loads the tspend to reissue, given either by the hash of
+// a tspend in the issued tspends state or by a file (or "-" for stdin) with
+// its hex encoding.
+func loadOriginalTSpend(state *issuedState, arg string) (*wire.MsgTx, error) {
+	if txHashRE.MatchString(arg) {
+		for _, issued := range state.PayoutSets {
+			for _, tspend := range issued {
+				if tspend.TxHash == arg {
+					return decodeIssued(tspend)
+				}
+			}
+		}
+		return nil, fmt.Errorf("tspend %s not found in %s", arg, state.path)
+	}
+
+	msgTxs, err := readTSpends(arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgTxs) != 1 {
+		return nil, fmt.Errorf("expected a single tspend in %s, found %d",
+			arg, len(msgTxs))
+	}
+	return msgTxs[0], nil
+}
+
+// checkNotMineable returns an error unless the tspend can no longer be mined:
+// its expiry must have passed and it must not have been mined already. Tspends
+// may only be mined in the treasury vote interval blocks of their voting
+// window, so those blocks are searched for it and any failure to fetch them is
+// returned as an error.
+func checkNotMineable(ctx context.Context, c *rpcclient.Client, cfg *config,
+	msgTx *wire.MsgTx) error {
+
+	height, err := currentHeight(ctx, cfg, c)
+	if err != nil {
+		return err
+	}
+	txHash := msgTx.TxHash()
+	if height < int64(msgTx.Expiry) {
+		return fmt.Errorf("tspend %s may still be mined: it expires at "+
+			"block %d and the current height is %d", txHash,
+			msgTx.Expiry, height)
+	}
+
+	tvi := cfg.chainParams.TreasuryVoteInterval
+	mul := cfg.chainParams.TreasuryVoteIntervalMultiplier
+	start, end, err := blockchain.CalcTSpendWindow(msgTx.Expiry, tvi, mul)
+	if err != nil {
+		return fmt.Errorf("tspend %s has an invalid expiry: %v", txHash, err)
+	}
+	for h := start; h <= end; h++ {
+		if !blockchain.IsTreasuryVoteInterval(uint64(h), tvi) {
+			continue
+		}
+		blockHash, err := c.GetBlockHash(ctx, int64(h))
+		if err != nil {
+			return fmt.Errorf("unable to fetch block %d of the voting "+
+				"window of tspend %s: %v", h, txHash, err)
+		}
+		block, err := c.GetBlock(ctx, blockHash)
+		if err != nil {
+			return fmt.Errorf("unable to fetch block %d of the voting "+
+				"window of tspend %s: %v", h, txHash, err)
+		}
+		for _, stx := range block.STransactions {
+			if stx.TxHash() == txHash {
+				return fmt.Errorf("tspend %s was mined in block %d",
+					txHash, h)
+			}
+		}
+	}
+	return nil
+}
+
+// runReissueCmd runs the reissue command, which generates a new tspend with
+// the same payouts as an expired (or rejected) one, with a fresh expiry and
+// OP_RETURN.
+func runReissueCmd(ctx context.Context, cfg *config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: reissue <txhash|file|->")
+	}
+
+	c, err := rpcclient.New(cfg.dcrdConnConfig(), nil)
+	if err != nil {
+		return err
+	}
+	defer c.Shutdown()
+
+	issued, err := loadIssuedState(cfg)
+	if err != nil {
+		return err
+	}
+	orig, err := loadOriginalTSpend(issued, args[0])
+	if err != nil {
+		return err
+	}
+	origHash := orig.TxHash()
+	payouts, err := tspendPayouts(orig, cfg.chainParams)
+	if err != nil {
+		return err
+	}
+	book, err := loadAddrBook(cfg.AddrBook, cfg.chainParams)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(book.Payees))
+	for name, addr := range book.Payees {
+		names[addr] = name
+	}
+	for _, p := range payouts {
+		p.name = names[p.address.String()]
+	}
+
+	// The original tspend, and any later reissue of the same payouts, must
+	// no longer be mineable.
+	if err := checkNotMineable(ctx, c, cfg, orig); err != nil {
+		return err
+	}
+	setHash := payoutSetHash(payouts)
+	if prev := issued.latest(setHash); prev != nil && prev.TxHash != origHash.String() {
+		prevTx, err := decodeIssued(prev)
+		if err != nil {
+			return err
+		}
+		if err := checkNotMineable(ctx, c, cfg, prevTx); err != nil {
+			return fmt.Errorf("the payouts of %s were already reissued: %v",
+				origHash, err)
+		}
+	}
+
+	expiry, err := loadExpiry(cfg, c, ctx)
+	if err != nil {
+		return err
+	}
+	msgTx, fee, _, err := buildTSpend(cfg, payouts, expiry)
+	if err != nil {
+		return err
+	}
+	err = enforceSigningPolicy(ctx, c, cfg, [][]*payout{payouts},
+		[]uint32{expiry})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range payouts {
+		log.Infof("Payout to %s: %s (fingerprint %s)",
+			payeeString(p.name, p.address), p.amount,
+			addrFingerprint(p.address))
+	}
+	log.Infof("Reissuing tspend %s (expiry %d) with expiry %d", origHash,
+		orig.Expiry, expiry)
+	if cfg.Unsigned != "" {
+		return writeUnsignedManifest(cfg, msgTx, payouts, fee)
+	}
+	if err := requireNoReviewers(cfg); err != nil {
+		return err
+	}
+
+	pubKeyBytes, err := signTSpends(cfg, msgTx)
+	if err != nil {
+		return err
+	}
+	if err := issued.record(setHash, msgTx, false); err != nil {
+		return err
+	}
+	if cfg.Publish {
+		if err := checkDuplicateTSpends(ctx, c, cfg, msgTx, payouts); err != nil {
+			return err
+		}
+		_, err := c.SendRawTransaction(ctx, msgTx, true)
+		if err != nil && !isAlreadyHaveTxErr(err) {
+			return fmt.Errorf("Failed to publish tspend: %v", err)
+		}
+		log.Infof("Published TSpend to dcrd at %s", cfg.DcrdConnect)
+		if err := issued.record(setHash, msgTx, true); err != nil {
+			return err
+		}
+	}
+	if err := writeTSpends(cfg, msgTx); err != nil {
+		return err
+	}
+
+	log.Infof("TSpend Hash: %s", msgTx.TxHash())
+	log.Infof("TSpend PubKey: %x", pubKeyBytes)
+	if !isPiKey(cfg.chainParams, pubKeyBytes) {
+		log.Warnf("Private key does not correspond to a public Pi Key " +
+			"for the specified chain")
+	}
+	return nil
+}
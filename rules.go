@@ -7,15 +7,31 @@ package main
 
 import (
 	"errors"
+	"fmt"
 
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
 	"github.com/decred/dcrd/dcrutil/v4"
 	"github.com/decred/dcrd/txscript/v4"
 	"github.com/decred/dcrd/txscript/v4/stdscript"
 	"github.com/decred/dcrd/wire"
 )
 
-// DefaultRelayFeePerKb is the default minimum relay fee policy for a mempool.
-const DefaultRelayFeePerKb dcrutil.Amount = 1e4
+const (
+	// DefaultRelayFeePerKb is the default minimum relay fee policy for a
+	// mempool.
+	DefaultRelayFeePerKb dcrutil.Amount = 1e4
+
+	// MaxStandardTxSize is the maximum size of a transaction accepted by a
+	// mempool with default policies.
+	MaxStandardTxSize = 100000
+
+	// MaxStandardSigScriptSize is the maximum size of a signature script
+	// accepted by a mempool with default policies.
+	MaxStandardSigScriptSize = 1650
+)
 
 // IsDustAmount determines whether a transaction output value and script length would
 // cause the output to be considered dust.  Transactions with dust outputs are
@@ -91,3 +107,79 @@ func sumOutputValues(outputs []*wire.TxOut) (totalOutput dcrutil.Amount) {
 	}
 	return totalOutput
 }
+
+// CheckSignedTSpend performs the consensus and default mempool policy tests on
+// a signed tspend: transaction sanity, tspend form and expiry, execution of the
+// signature script with the treasury rules, validity of the signature,
+// standardness of its size, outputs and fee. It returns the public key that
+// signed the tspend.
+func CheckSignedTSpend(msgTx *wire.MsgTx, params *chaincfg.Params) ([]byte, error) {
+	err := blockchain.CheckTransactionSanity(msgTx, uint64(params.MaxTxSize))
+	if err != nil {
+		return nil, fmt.Errorf("transaction sanity check failed: %v", err)
+	}
+	sig, pubKey, err := stake.CheckTSpend(msgTx)
+	if err != nil {
+		return nil, fmt.Errorf("CheckTSpend failed: %v", err)
+	}
+	tvi := params.TreasuryVoteInterval
+	if msgTx.Expiry < 2 || !blockchain.IsTreasuryVoteInterval(uint64(msgTx.Expiry-2), tvi) {
+		return nil, fmt.Errorf("expiry %d is not 2 blocks after a treasury "+
+			"vote interval (%d blocks)", msgTx.Expiry, tvi)
+	}
+
+	// Execute the signature script with the treasury rules and verify the
+	// signature it carries.
+	vm, err := txscript.NewEngine(nil, msgTx, 0, txscript.ScriptVerifyTreasury,
+		0, nil)
+	if err == nil {
+		err = vm.Execute()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signature script execution failed: %v", err)
+	}
+	sigHash, err := txscript.CalcSignatureHash(nil, txscript.SigHashAll, msgTx,
+		0, nil)
+	if err != nil {
+		return nil, err
+	}
+	schnorrSig, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+	schnorrPubKey, err := schnorr.ParsePubKey(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	if !schnorrSig.Verify(sigHash, schnorrPubKey) {
+		return nil, errors.New("signature does not verify")
+	}
+
+	// Standardness.
+	size := msgTx.SerializeSize()
+	if size > MaxStandardTxSize {
+		return nil, fmt.Errorf("transaction size %d is larger than the "+
+			"standard maximum of %d", size, MaxStandardTxSize)
+	}
+	if n := len(msgTx.TxIn[0].SignatureScript); n > MaxStandardSigScriptSize {
+		return nil, fmt.Errorf("signature script size %d is larger than "+
+			"the standard maximum of %d", n, MaxStandardSigScriptSize)
+	}
+	for i, txOut := range msgTx.TxOut[1:] {
+		switch stdscript.DetermineScriptType(txOut.Version, txOut.PkScript) {
+		case stdscript.STTreasuryGenPubKeyHash, stdscript.STTreasuryGenScriptHash:
+		default:
+			return nil, fmt.Errorf("output %d is not a standard treasury "+
+				"payout", i+1)
+		}
+		if err := CheckOutput(txOut, DefaultRelayFeePerKb); err != nil {
+			return nil, fmt.Errorf("output %d: %v", i+1, err)
+		}
+	}
+	fee := dcrutil.Amount(msgTx.TxIn[0].ValueIn) - sumOutputValues(msgTx.TxOut)
+	if minFee := FeeForSerializeSize(DefaultRelayFeePerKb, size); fee < minFee {
+		return nil, fmt.Errorf("fee %s is lower than the minimum relay fee "+
+			"%s", fee, minFee)
+	}
+	return pubKey, nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v4"
+	chainjson "github.com/decred/dcrd/rpc/jsonrpc/types/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/wire"
+	"github.com/matheusd/tspend/internal/treasury"
+)
+
+// auditSegmentSize is the number of consecutive heights fetched and audited at
+// a time, which bounds the number of blocks held in memory.
+const auditSegmentSize = 16 * treasury.ScanChunkSize
+
+// auditDiscrepancy is an inconsistency found while auditing a block.
+type auditDiscrepancy struct {
+	Height  int64          `json:"height"`
+	Hash    chainhash.Hash `json:"hash"`
+	Kind    string         `json:"kind"`
+	Details string         `json:"details"`
+}
+
+// auditResult is the result of auditing a range of blocks.
+type auditResult struct {
+	Network       string             `json:"network"`
+	FromHeight    int64              `json:"fromheight"`
+	ToHeight      int64              `json:"toheight"`
+	FinalBalance  dcrutil.Amount     `json:"finalbalance"`
+	Discrepancies []auditDiscrepancy `json:"discrepancies"`
+}
+
+// expectedTreasuryUpdates recomputes the treasury updates of a block from its
+// stake transactions, in the same order dcrd records them: treasury bases and
+// treasury adds add their first output, while each tspend subtracts the total
+// of its outputs and then its fee.
+func expectedTreasuryUpdates(block *wire.MsgBlock) []int64 {
+	var updates []int64
+	for _, tx := range block.STransactions {
+		switch {
+		case stake.IsTreasuryBase(tx), stake.IsTAdd(tx):
+			updates = append(updates, tx.TxOut[0].Value)
+		case stake.IsTSpend(tx):
+			var totalOut int64
+			for _, out := range tx.TxOut {
+				totalOut += out.Value
+			}
+			fee := tx.TxIn[0].ValueIn - totalOut
+			updates = append(updates, -totalOut, -fee)
+		}
+	}
+	return updates
+}
+
+// fetchAuditedBlock returns the treasury changes of the given block, from the
+// cache or from dcrd, along with the updates recomputed from its stake
+// transactions.
+func fetchAuditedBlock(ctx context.Context, c *rpcclient.Client, bc *blockCache,
+	hash *chainhash.Hash) (*cachedBlock, error) {
+
+	var b cachedBlock
+	if cached := bc.block(*hash); cached != nil {
+		b = *cached
+	} else {
+		fetched, err := fetchBlock(ctx, c, hash)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch treasury balance "+
+				"of block %s: %v", hash, err)
+		}
+		b = *fetched
+	}
+	block, err := c.GetBlock(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	b.Expected = expectedTreasuryUpdates(block)
+	b.Audited = true
+	return &b, nil
+}
+
+// equalUpdates returns true if both lists of updates are the same.
+func equalUpdates(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// auditTreasury verifies the treasury balance reported by dcrd for every block
+// in the range [fromHeight, toHeight]. The updates of each block must match
+// the ones recomputed from its stake transactions and its balance must be the
+// balance of the previous block plus the updates of the block that reached
+// maturity.
+//
+// Blocks are fetched (or read from the block cache) one segment at a time and
+// checked as they arrive, so only a maturity period worth of sums is kept
+// across segments.
+func auditTreasury(ctx context.Context, c *rpcclient.Client, cfg *config,
+	rules treasury.ConsensusRules, binfo *chainjson.GetBlockChainInfoResult) (*auditResult, error) {
+
+	params := cfg.chainParams
+	activation, err := activationHeight(rules)
+	if err != nil && cfg.FromHeight == 0 {
+		return nil, err
+	}
+
+	toHeight := cfg.ToHeight
+	if toHeight == 0 {
+		toHeight = binfo.Blocks
+		if cfg.Height != 0 {
+			toHeight = int64(cfg.Height)
+		}
+	}
+	fromHeight := cfg.FromHeight
+	if fromHeight < activation {
+		fromHeight = activation
+	}
+	if toHeight < fromHeight {
+		return nil, fmt.Errorf("toheight (%d) must not be lower than "+
+			"fromheight (%d)", toHeight, fromHeight)
+	}
+
+	// Blocks up to a full maturity period before the first audited one
+	// are needed to check its balance.
+	maturity := int64(params.CoinbaseMaturity)
+	scanFrom := fromHeight - maturity
+	if scanFrom < activation {
+		scanFrom = activation
+	}
+	if scanFrom < 0 {
+		scanFrom = 0
+	}
+
+	res := &auditResult{
+		Network:       params.Name,
+		FromHeight:    fromHeight,
+		ToHeight:      toHeight,
+		Discrepancies: []auditDiscrepancy{},
+	}
+	bc := loadBlockCache(cfg.blockCachePath())
+	err = auditBlocks(ctx, c, cfg, bc, res, scanFrom)
+	if saveErr := bc.save(); saveErr != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "Unable to save block cache: %v\n", saveErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// auditBlocks audits the blocks from scanFrom to the end of the audited range,
+// reporting the discrepancies of the audited heights in res.
+func auditBlocks(ctx context.Context, c *rpcclient.Client, cfg *config,
+	bc *blockCache, res *auditResult, scanFrom int64) error {
+
+	maturity := int64(cfg.chainParams.CoinbaseMaturity)
+	report := func(height int64, hash chainhash.Hash, kind, format string, args ...interface{}) {
+		res.Discrepancies = append(res.Discrepancies, auditDiscrepancy{
+			Height:  height,
+			Hash:    hash,
+			Kind:    kind,
+			Details: fmt.Sprintf(format, args...),
+		})
+	}
+
+	// The sum of the expected updates of the last maturity period of
+	// blocks, indexed by height modulo the maturity, and the balance of
+	// the previous block. Blocks before the scanned range (before the
+	// treasury activation) have no balance.
+	sums := make([]int64, maturity)
+	var prevBalance int64
+	for start := scanFrom; start <= res.ToHeight; start += auditSegmentSize {
+		end := start + auditSegmentSize - 1
+		if end > res.ToHeight {
+			end = res.ToHeight
+		}
+		endHash, err := c.GetBlockHash(ctx, end)
+		if err != nil {
+			return err
+		}
+		hashes, err := resolveHashes(ctx, c, bc, *endHash, uint32(end),
+			uint32(start), cfg.Workers)
+		if err != nil {
+			return err
+		}
+
+		blocks := make([]*cachedBlock, len(hashes))
+		var missing []uint32
+		for i, hash := range hashes {
+			if b := bc.block(hash); b != nil && b.Audited {
+				blocks[i] = b
+			} else {
+				missing = append(missing, uint32(start)+uint32(i))
+			}
+		}
+		err = treasury.RunChunked(ctx, missing, cfg.Workers, func(h uint32) error {
+			i := int64(h) - start
+			b, err := fetchAuditedBlock(ctx, c, bc, &hashes[i])
+			if err != nil {
+				return err
+			}
+			if b.Height != h {
+				return fmt.Errorf("block %s reported height %d "+
+					"while expected %d", hashes[i], b.Height, h)
+			}
+			blocks[i] = b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, hash := range hashes {
+			bc.put(hash, blocks[i])
+		}
+		bc.setTip(*endHash, uint32(end))
+
+		for i, b := range blocks {
+			h := start + int64(i)
+			var sum int64
+			for _, v := range b.Expected {
+				sum += v
+			}
+
+			// The balance only changes once the updates of a block
+			// reach maturity.
+			var matured int64
+			if h-maturity >= scanFrom {
+				matured = sums[h%maturity]
+			}
+			sums[h%maturity] = sum
+			want := prevBalance + matured
+			prevBalance = b.Balance
+			if h < res.FromHeight {
+				continue
+			}
+
+			if !equalUpdates(b.Updates, b.Expected) {
+				report(h, hashes[i], "updates", "dcrd reported "+
+					"updates %v while its stake transactions "+
+					"imply %v", b.Updates, b.Expected)
+			}
+			if b.Balance != want {
+				report(h, hashes[i], "balance", "dcrd reported "+
+					"balance %s while expected %s (previous "+
+					"balance %s, matured updates %s)",
+					dcrutil.Amount(b.Balance), dcrutil.Amount(want),
+					dcrutil.Amount(want-matured),
+					dcrutil.Amount(matured))
+			}
+		}
+		res.FinalBalance = dcrutil.Amount(prevBalance)
+	}
+	return nil
+}
+
+// printAudit prints the audit result in human readable form.
+func printAudit(res *auditResult) {
+	println("Audited treasury of blocks %d - %d (%s)", res.FromHeight,
+		res.ToHeight, res.Network)
+	println("Final balance: %s", res.FinalBalance)
+	if len(res.Discrepancies) == 0 {
+		println("No discrepancies found")
+		return
+	}
+	for _, d := range res.Discrepancies {
+		println("")
+		println("Block %d (%s): %s mismatch", d.Height, d.Hash, d.Kind)
+		println("  %s", d.Details)
+	}
+}
@@ -15,6 +15,7 @@ import (
 var (
 	defaultDcrdDir      = dcrutil.AppDataDir("dcrd", false)
 	defaultDcrdCertPath = filepath.Join(defaultDcrdDir, "rpc.cert")
+	defaultCacheDir     = dcrutil.AppDataDir("spendestimate", false)
 
 	errCmdDone = errors.New("command is done")
 )
@@ -35,9 +36,34 @@ type config struct {
 	DcrdPass      string `short:"P" long:"dcrdpass" description:"RPC password to authenticate with dcrd"`
 
 	Height uint32 `long:"height" description:"Perform estimate for the specified height instead of tip"`
+	JSON   bool   `long:"json" description:"Output the estimate as a JSON document"`
+
+	// Block Scanning Options
+
+	Workers  int    `long:"workers" description:"Number of concurrent requests made to dcrd while scanning blocks"`
+	CacheDir string `long:"cachedir" description:"Directory where the per-block treasury changes are cached"`
+	NoCache  bool   `long:"nocache" description:"Do not use (or update) the on-disk block cache"`
+
+	// Consensus Rules Options
+
+	TreasuryHeight int64 `long:"treasuryheight" description:"Override the height the treasury agenda activated at; -1 if not active (default: query dcrd)"`
+	DCP0007Height  int64 `long:"dcp0007height" description:"Override the height the DCP0007 treasury expenditure policy activated at; -1 if not active (default: query dcrd)"`
+
+	// Plan Options
+
+	PlanFile string `long:"planfile" description:"CSV file with the planned tspends for the plan command (<amount>,<expiry|tvi>,<height>[,<label>])"`
+
+	// Projection and History Options
+
+	TVIs       int    `long:"tvis" description:"Number of TVIs after the tip to project with the project command"`
+	FromHeight int64  `long:"fromheight" description:"First height of the range used by the project, history and audit commands (history and audit default: treasury activation)"`
+	ToHeight   int64  `long:"toheight" description:"Last height of the range used by the project and audit commands"`
+	CSV        string `long:"csv" description:"Also write the projection or history to the specified CSV file"`
 
 	// The rest of the members of this struct are filled by loadConfig().
 
+	command string
+
 	// activeNet   chainNetwork
 	chainParams *chaincfg.Params
 }
@@ -52,14 +78,27 @@ func (c *config) dcrdConnConfig() *rpcclient.ConnConfig {
 	}
 }
 
+// blockCachePath returns the path to the block cache file of the active
+// network or an empty string if the cache is disabled.
+func (c *config) blockCachePath() string {
+	if c.NoCache {
+		return ""
+	}
+	return filepath.Join(c.CacheDir, c.chainParams.Name, "blocks.gob")
+}
+
 func loadConfig() (*config, error) {
 	// Default config.
 	cfg := config{
 		DcrdCertPath: defaultDcrdCertPath,
+		Workers:      8,
+		CacheDir:     defaultCacheDir,
+		TVIs:         24,
 	}
 
 	preParser := flags.NewParser(&cfg, flags.HelpFlag)
-	_, err := preParser.Parse()
+	preParser.Usage = "[OPTIONS] [estimate|plan|project|history|audit]"
+	args, err := preParser.Parse()
 	if err != nil {
 		if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
 			fmt.Fprintln(os.Stderr, err)
@@ -67,6 +106,25 @@ func loadConfig() (*config, error) {
 		}
 	}
 
+	cfg.command = "estimate"
+	if len(args) > 0 {
+		cfg.command = args[0]
+	}
+	switch cfg.command {
+	case "estimate":
+	case "plan":
+		if cfg.PlanFile == "" {
+			return nil, fmt.Errorf("the plan command requires --planfile")
+		}
+	case "project":
+		if cfg.TVIs <= 0 {
+			return nil, fmt.Errorf("--tvis must be a positive number")
+		}
+	case "history", "audit":
+	default:
+		return nil, fmt.Errorf("unknown command %q", cfg.command)
+	}
+
 	switch {
 	case (cfg.MainNet && !(cfg.TestNet || cfg.SimNet)):
 		fallthrough
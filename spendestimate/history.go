@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/matheusd/tspend/internal/treasury"
+)
+
+// historyTSpend is a tspend mined in the chain along with the state of its
+// expenditure policy window at the time it was mined.
+type historyTSpend struct {
+	Hash        chainhash.Hash `json:"hash"`
+	MinedHeight uint32         `json:"minedheight"`
+	MinedHash   chainhash.Hash `json:"minedhash"`
+	MinedTime   time.Time      `json:"minedtime"`
+	Amount      dcrutil.Amount `json:"amount"`
+	Payouts     int            `json:"payouts"`
+	PiKey       string         `json:"pikey"`
+
+	// WindowIncome is the amount added to the treasury in the policy
+	// window ending at the mined height and WindowSpent is the amount
+	// spent in that window before this tspend. Allowance is the maximum
+	// expenditure of the window under the consensus rules in effect.
+	WindowIncome   dcrutil.Amount `json:"windowincome"`
+	WindowSpent    dcrutil.Amount `json:"windowspent"`
+	Allowance      dcrutil.Amount `json:"allowance"`
+	Utilization    float64        `json:"utilization"`
+	ConsensusRules string         `json:"consensusrules"`
+}
+
+// historyResult is the full treasury spending history.
+type historyResult struct {
+	Network      string          `json:"network"`
+	FromHeight   int64           `json:"fromheight"`
+	ToHeight     int64           `json:"toheight"`
+	PolicyWindow int64           `json:"policywindow"`
+	TotalSpent   dcrutil.Amount  `json:"totalspent"`
+	TSpends      []historyTSpend `json:"tspends"`
+}
+
+// treasuryHistory scans the chain from fromHeight (or the treasury activation
+// height) up to the tip and reports every tspend mined along with the
+// utilization of its expenditure policy window.
+func treasuryHistory(ctx context.Context, c *rpcclient.Client, cfg *config,
+	rules treasury.ConsensusRules) (*historyResult, error) {
+
+	params := cfg.chainParams
+	activation, err := activationHeight(rules)
+	if err != nil && cfg.FromHeight == 0 {
+		return nil, err
+	}
+	fromHeight := cfg.FromHeight
+	if fromHeight < activation {
+		fromHeight = activation
+	}
+
+	tipHash, tipHeight, err := c.GetBestBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Height != 0 {
+		tipHeight = int64(cfg.Height)
+		tipHash, err = c.GetBlockHash(ctx, tipHeight)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fromHeight > tipHeight {
+		return nil, fmt.Errorf("fromheight (%d) is after the tip (%d)",
+			fromHeight, tipHeight)
+	}
+
+	// Also scan the policy windows before the first height, so that the
+	// utilization of the first tspends is complete under either policy.
+	policy := treasury.NewPolicy(params, rules)
+	policyWindow := policy.Window
+	nbPriorWindows := int64(params.TreasuryExpenditurePolicy)
+	scanFrom := fromHeight - policyWindow*(nbPriorWindows+1) + 1
+	if scanFrom < activation {
+		scanFrom = activation
+	}
+	if scanFrom < 0 {
+		scanFrom = 0
+	}
+
+	bc := loadBlockCache(cfg.blockCachePath())
+	hashes, blocks, err := scanAndSaveBlocks(ctx, c, bc, *tipHash,
+		uint(tipHeight-scanFrom+1), cfg.Workers)
+	if err != nil {
+		return nil, fmt.Errorf("unable to scan treasury history: %v", err)
+	}
+
+	// Cumulative income and spending up to (and including) each block, so
+	// that the totals of any window can be computed by subtraction.
+	income := make([]dcrutil.Amount, len(blocks)+1)
+	spent := make([]dcrutil.Amount, len(blocks)+1)
+	for i, b := range blocks {
+		income[i+1], spent[i+1] = income[i], spent[i]
+		for _, v := range b.Updates {
+			if v > 0 {
+				income[i+1] += dcrutil.Amount(v)
+			}
+		}
+		for _, ts := range b.TSpends {
+			spent[i+1] += dcrutil.Amount(ts.Amount)
+		}
+	}
+
+	res := &historyResult{
+		Network:      params.Name,
+		FromHeight:   fromHeight,
+		ToHeight:     tipHeight,
+		PolicyWindow: policyWindow,
+		TSpends:      []historyTSpend{},
+	}
+	windowStart := func(i int) int {
+		if start := i - int(policyWindow) + 1; start > 0 {
+			return start
+		}
+		return 0
+	}
+
+	// The income and spending of the policy window ending at a given
+	// height, as needed by the expenditure policy.
+	windowTotal := func(sums []dcrutil.Amount, end int64) dcrutil.Amount {
+		i := int(end - scanFrom)
+		if i < 0 {
+			return 0
+		}
+		return sums[i+1] - sums[windowStart(i)]
+	}
+	windowIncomeAt := func(end int64) dcrutil.Amount {
+		return windowTotal(income, end)
+	}
+	windowSpentAt := func(end int64) dcrutil.Amount {
+		return windowTotal(spent, end)
+	}
+	for i, b := range blocks {
+		if int64(b.Height) < fromHeight || len(b.TSpends) == 0 {
+			continue
+		}
+		start := windowStart(i)
+		windowIncome := income[i+1] - income[start]
+		windowSpent := spent[i] - spent[start]
+		ruleSet := rules.At(int64(b.Height))
+		allowance := policy.Limit(int64(b.Height), windowIncomeAt,
+			windowSpentAt)
+		for _, ts := range b.TSpends {
+			hts := historyTSpend{
+				Hash:           ts.Hash,
+				MinedHeight:    b.Height,
+				MinedHash:      hashes[i],
+				MinedTime:      time.Unix(b.Time, 0).UTC(),
+				Amount:         dcrutil.Amount(ts.Amount),
+				Payouts:        ts.Payouts,
+				PiKey:          hex.EncodeToString(ts.PiKey),
+				WindowIncome:   windowIncome,
+				WindowSpent:    windowSpent,
+				Allowance:      allowance,
+				ConsensusRules: ruleSet,
+			}
+			if allowance > 0 {
+				hts.Utilization = float64(windowSpent+hts.Amount) /
+					float64(allowance) * 100
+			}
+			res.TSpends = append(res.TSpends, hts)
+			res.TotalSpent += hts.Amount
+
+			// Tspends mined earlier in the same block count
+			// against the following ones.
+			windowSpent += hts.Amount
+		}
+	}
+
+	return res, nil
+}
+
+// printHistory prints the treasury history in human readable form.
+func printHistory(res *historyResult) {
+	println("Treasury history of blocks %d - %d (%s)    Policy Window: %d blocks",
+		res.FromHeight, res.ToHeight, res.Network, res.PolicyWindow)
+	println("Total spent: %s in %d %s", res.TotalSpent, len(res.TSpends),
+		plural(int64(len(res.TSpends)), "tspend", "tspends"))
+	for _, ts := range res.TSpends {
+		println("")
+		println("TSpend %s", ts.Hash)
+		println("  Mined on block %d (%s) at %s", ts.MinedHeight,
+			ts.MinedHash, ts.MinedTime.Format("2006-01-02 15:04 MST"))
+		println("  Amount: %s in %d %s   Signed by: %s", ts.Amount,
+			ts.Payouts, plural(int64(ts.Payouts), "payout", "payouts"),
+			ts.PiKey)
+		println("  Window income: %s   Allowance: %s (%s)   Utilization: %.2f%%",
+			ts.WindowIncome, ts.Allowance, ts.ConsensusRules,
+			ts.Utilization)
+	}
+}
+
+// writeHistoryCSV writes the treasury history as a CSV file. Amounts are in
+// atoms.
+func writeHistoryCSV(path string, res *historyResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating csv file: %v", err)
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"hash", "mined_height", "mined_hash", "mined_time",
+		"amount", "payouts", "pikey", "window_income", "window_spent",
+		"allowance", "utilization", "consensus_rules"})
+	itoa := func(a dcrutil.Amount) string {
+		return strconv.FormatInt(int64(a), 10)
+	}
+	for _, ts := range res.TSpends {
+		w.Write([]string{
+			ts.Hash.String(),
+			strconv.FormatUint(uint64(ts.MinedHeight), 10),
+			ts.MinedHash.String(),
+			ts.MinedTime.Format(time.RFC3339),
+			itoa(ts.Amount),
+			strconv.Itoa(ts.Payouts),
+			ts.PiKey,
+			itoa(ts.WindowIncome),
+			itoa(ts.WindowSpent),
+			itoa(ts.Allowance),
+			strconv.FormatFloat(ts.Utilization, 'f', 2, 64),
+			ts.ConsensusRules,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
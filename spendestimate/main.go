@@ -1,20 +1,18 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"sort"
 	"time"
 
-	"github.com/decred/dcrd/blockchain/stake/v5"
 	"github.com/decred/dcrd/blockchain/standalone/v2"
 	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
 	"github.com/decred/dcrd/dcrutil/v4"
-	chainjson "github.com/decred/dcrd/rpc/jsonrpc/types/v4"
 	"github.com/decred/dcrd/rpcclient/v8"
-	"github.com/decred/dcrd/wire"
+	"github.com/matheusd/tspend/internal/treasury"
 )
 
 func println(format string, args ...interface{}) {
@@ -22,153 +20,107 @@ func println(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stdout, "\n")
 }
 
-type tspend struct {
-	hash        chainhash.Hash
-	minedHash   chainhash.Hash
-	minedHeight uint32
-	amount      dcrutil.Amount
+// tspendEstimate is the estimate of the spendable amount after a tspend mined
+// in the current policy window leaves it.
+type tspendEstimate struct {
+	Hash             chainhash.Hash `json:"hash"`
+	MinedHeight      uint32         `json:"minedheight"`
+	Amount           dcrutil.Amount `json:"amount"`
+	LeaveHeight      int64          `json:"leavewindowheight"`
+	BlocksToLeave    int64          `json:"blockstoleave"`
+	LeaveTime        time.Time      `json:"leavewindowtime"`
+	SpendableAfter   dcrutil.Amount `json:"spendableafter"`
+	WithMempool      dcrutil.Amount `json:"spendableafterwithmempool"`
+	ConsensusRules   string         `json:"consensusrules"`
+	BlocksToMaturity int64          `json:"blockstomaturity,omitempty"`
 }
 
-func pastTreasuryChanges(ctx context.Context, c *rpcclient.Client, node chainhash.Hash, nbBlocks uint) (
-	added, spent, initialBalance, finalBalance dcrutil.Amount, tspends []tspend, prevNode chainhash.Hash, err error) {
-
-	var tbalance *chainjson.GetTreasuryBalanceResult
-	var block *wire.MsgBlock
-	var header *wire.BlockHeader
-	setFinalBal := true
-	for ; err == nil && nbBlocks > 0; node = prevNode {
-		// Find the previous block.
-		header, err = c.GetBlockHeader(ctx, &node)
-		if err != nil {
-			return
-		}
-
-		prevNode = header.PrevBlock
-		nbBlocks -= 1
-
-		// Fetch the treasury changes for this block.
-		tbalance, err = c.GetTreasuryBalance(ctx, &node, true)
-		if err != nil {
-			return
-		}
-
-		// Find adds and check for tspends.
-		tspendCount := 0
-		for _, v := range tbalance.Updates {
-			if v > 0 {
-				added += dcrutil.Amount(v)
-			}
-			if v < 0 {
-				spent += -dcrutil.Amount(v)
-				tspendCount += 1
-			}
-		}
-
-		// Set initial and final balances.
-		initialBalance = dcrutil.Amount(int64(tbalance.Balance))
-		if setFinalBal {
-			finalBalance = dcrutil.Amount(tbalance.Balance)
-			setFinalBal = false
-		}
-
-		if tspendCount == 0 {
-			continue
-		}
-
-		// Block has TSpends. Fetch the block and find them.
-		block, err = c.GetBlock(ctx, &node)
-		if err != nil {
-			return
-		}
-
-		var blockTspends []tspend
-		for _, tx := range block.STransactions {
-			if stake.IsTSpend(tx) {
-				ts := tspend{
-					hash:        tx.TxHash(),
-					minedHash:   node,
-					minedHeight: header.Height,
-					amount:      dcrutil.Amount(tx.TxIn[0].ValueIn),
-				}
-				blockTspends = append(blockTspends, ts)
-			}
-		}
-		if len(blockTspends) == tspendCount {
-			err = fmt.Errorf("found only %d tspends while expected "+
-				"%d in block %s", len(blockTspends), tspendCount,
-				node)
-			return
-		}
-		tspends = append(tspends, blockTspends...)
-	}
+// newTSpendEstimate is the estimate for a new tspend generated at the tip.
+type newTSpendEstimate struct {
+	Expiry         uint32         `json:"expiry"`
+	ExpiryTime     time.Time      `json:"expirytime"`
+	VoteEnd        uint32         `json:"voteend"`
+	Spendable      dcrutil.Amount `json:"spendable"`
+	WithMempool    dcrutil.Amount `json:"spendablewithmempool"`
+	ConsensusRules string         `json:"consensusrules"`
+}
 
-	return
+// estimateResult is the full result of a spending estimate.
+type estimateResult struct {
+	Network        string            `json:"network"`
+	TipHeight      int64             `json:"tipheight"`
+	TipHash        chainhash.Hash    `json:"tiphash"`
+	TipTime        time.Time         `json:"tiptime"`
+	ConsensusRules string            `json:"consensusrules"`
+	PolicyWindow   int64             `json:"policywindow"`
+	TotalBalance   dcrutil.Amount    `json:"totalbalance"`
+	TBaseAdded     dcrutil.Amount    `json:"windowtbaseadded"`
+	TAddAdded      dcrutil.Amount    `json:"windowtaddadded"`
+	Spent          dcrutil.Amount    `json:"windowspent"`
+	Spendable      dcrutil.Amount    `json:"spendable"`
+	WithMempool    dcrutil.Amount    `json:"spendablewithmempool"`
+	TSpends        []tspendEstimate  `json:"tspends"`
+	MempoolTSpends []mempoolTSpend   `json:"mempooltspends"`
+	NewTSpend      newTSpendEstimate `json:"newtspend"`
 }
 
 // estimateSpend is the main workhorse for this app.
-func estimateSpend(ctx context.Context, c *rpcclient.Client, cfg *config) error {
-	tipHash, tipHeight, err := c.GetBestBlock(ctx)
-	if err != nil {
-		return err
-	}
-
-	if cfg.Height != 0 {
-		tipHeight = int64(cfg.Height)
-		tipHash, err = c.GetBlockHash(ctx, tipHeight)
-		if err != nil {
-			return err
-		}
-	}
-
-	params := cfg.chainParams
-	tvi := int64(params.TreasuryVoteInterval)
-	mul := int64(params.TreasuryVoteIntervalMultiplier)
-	policyWindow := tvi * mul * int64(params.TreasuryExpenditureWindow)
-	subCache := standalone.NewSubsidyCache(params)
-
-	println("Consensus rules: DCP0007    Policy Window: %d blocks", policyWindow)
-	println("Tip Block: %d - %s (%s)", tipHeight, tipHash, params.Name)
-
-	// Fetch the treasury changes from the tip height for the past
-	// expenditure policy window.
-	added, spent, _, finalBalance, tspends, _, err := pastTreasuryChanges(ctx,
-		c, *tipHash, uint(policyWindow))
-	if err != nil {
-		return fmt.Errorf("unable to fetch past treasury changes: %v", err)
+func estimateSpend(s *treasuryState) *estimateResult {
+	params := s.params
+	tvi, mul := s.tvi, s.mul
+	policyWindow := s.policyWindow
+	tipHeight := s.tipHeight
+	tc := s.changes
+	tspends := tc.tspends
+
+	res := &estimateResult{
+		Network:        params.Name,
+		TipHeight:      tipHeight,
+		TipHash:        s.tipHash,
+		TipTime:        s.tipTime,
+		ConsensusRules: s.rules.At(tipHeight),
+		PolicyWindow:   policyWindow,
+		TSpends:        []tspendEstimate{},
+		MempoolTSpends: append([]mempoolTSpend{}, s.mempool...),
 	}
 
-	// Sort tspends by increasing height.
-	sort.Slice(tspends, func(i, j int) bool {
-		return tspends[i].minedHeight < tspends[j].minedHeight
-	})
-
-	// Determine how much is spendable right now.
+	// Determine how much is spendable right now. Under DCP0007, this is
+	// based on the actual income of the window.
+	added := tc.added()
 	addedPlusAllowance := added + added/2
+	if res.ConsensusRules != treasury.RulesDCP0007 {
+		addedPlusAllowance = s.policyLimitAt(tipHeight)
+	}
 	var spendable dcrutil.Amount
-	if addedPlusAllowance > spent {
-		spendable = addedPlusAllowance - spent
+	if addedPlusAllowance > tc.spent {
+		spendable = addedPlusAllowance - tc.spent
 	}
 
-	println("Total Treasury Balance: %s", dcrutil.Amount(finalBalance))
-	println("Current Spendable Balance: %s", spendable)
+	res.TotalBalance = tc.finalBalance
+	res.TBaseAdded = tc.tbaseAdded
+	res.TAddAdded = tc.taddAdded
+	res.Spent = tc.spent
+	res.Spendable = spendable
+	res.WithMempool = spendable
+	for _, mts := range s.mempool {
+		res.WithMempool -= mts.Amount
+	}
+	if res.WithMempool < 0 {
+		res.WithMempool = 0
+	}
 
 	// Loop over the tspends, estimating how much will be available after
 	// they leave their respective windows.
-	if len(tspends) == 0 {
-		println("No tspends within policy window")
-	}
 	for i, ts := range tspends {
 		blocksFromTip := tipHeight - int64(ts.minedHeight)
 		blocksToLeave := int64(policyWindow) - blocksFromTip
 		tviAfterLeft := tipHeight + blocksToLeave
-		timeToLeave := time.Duration(blocksToLeave) * params.TargetTimePerBlock
 
-		// Sum the treasury bases that will happen in the block after
-		// this tspend clears its corresponding window, then subtract
-		// any remaining tspends still in effect.
-		tbaseEstimate := sumTbases(tviAfterLeft, policyWindow,
-			params.SubsidyReductionInterval, subCache)
-		spendEstimate := tbaseEstimate + tbaseEstimate/2
+		// Estimate the policy limit in the block after this tspend
+		// clears its corresponding window (which includes the treasury
+		// adds still inside it), then subtract any remaining tspends
+		// still in effect.
+		spendEstimate := s.policyLimitAt(tviAfterLeft)
 		for _, ots := range tspends[i+1:] {
 			blocksToLeave := int64(policyWindow+tvi*2) - (tviAfterLeft - int64(ots.minedHeight))
 			if blocksToLeave > 0 {
@@ -176,58 +128,131 @@ func estimateSpend(ctx context.Context, c *rpcclient.Client, cfg *config) error
 			}
 		}
 
-		println("")
-		println("TSpend of %s on block %d (TSpend hash %s)",
-			ts.amount, ts.minedHeight, ts.hash)
-		println("  Leaves policy window on block %d (%d %s, %s left)",
-			tviAfterLeft, blocksToLeave,
-			plural(blocksToLeave, "block", "blocks"),
-			formatDuration(timeToLeave))
-		println("  Estimated spendable after cleared: %s",
-			spendEstimate)
+		tse := tspendEstimate{
+			Hash:           ts.hash,
+			MinedHeight:    ts.minedHeight,
+			Amount:         ts.amount,
+			LeaveHeight:    tviAfterLeft,
+			BlocksToLeave:  blocksToLeave,
+			LeaveTime:      s.heightTime(tviAfterLeft),
+			SpendableAfter: spendEstimate,
+			WithMempool:    spendEstimate - s.mempoolAt(tviAfterLeft),
+			ConsensusRules: s.rules.At(tviAfterLeft),
+		}
 		blocksToMaturity := int64(params.CoinbaseMaturity) - blocksFromTip
 		if blocksToMaturity > 0 {
-			println("  NOTE: This TSpend is not yet reflected "+
-				"in the total treasury balance (%d %s "+
-				"to maturity)", blocksToMaturity,
-				plural(blocksToMaturity, "block", "blocks"),
-			)
+			tse.BlocksToMaturity = blocksToMaturity
 		}
+		res.TSpends = append(res.TSpends, tse)
 	}
 
 	// Make an estimate if we generated a TSpend right now, how much it
 	// would be available up to its expiry.
-	blocksToTVI := tvi - (tipHeight % tvi)
-	tooCloseThresh := tvi / 4
-	isTooClose := blocksToTVI < tooCloseThresh
-	expiry := standalone.CalcTSpendExpiry(tipHeight, uint64(tvi), uint64(mul))
-
-	if isTooClose {
-		// Advance to following TVI.
-		expiry = standalone.CalcTSpendExpiry(tipHeight+blocksToTVI, uint64(tvi), uint64(mul))
-	}
+	expiry := s.newTSpendExpiry()
 	_, endVoting, _ := standalone.CalcTSpendWindow(expiry, uint64(tvi), uint64(mul))
-	tbaseEstimate := sumTbases(int64(endVoting), policyWindow,
-		params.SubsidyReductionInterval, subCache)
-	spendEstimate := tbaseEstimate + tbaseEstimate/2
+	spendEstimate := s.policyLimitAt(int64(endVoting))
 	for _, ts := range tspends {
 		blocksToLeave := policyWindow - (int64(endVoting) - int64(ts.minedHeight))
 		if blocksToLeave > 0 {
 			spendEstimate -= ts.amount
 		}
 	}
-	timeToExpiry := time.Duration(int64(expiry)-tipHeight) * params.TargetTimePerBlock
 
+	res.NewTSpend = newTSpendEstimate{
+		Expiry:         expiry,
+		ExpiryTime:     s.heightTime(int64(expiry)),
+		VoteEnd:        endVoting,
+		Spendable:      spendEstimate,
+		WithMempool:    spendEstimate - s.mempoolAt(int64(endVoting)),
+		ConsensusRules: s.rules.At(int64(endVoting)),
+	}
+
+	return res
+}
+
+// printEstimate prints the estimate in human readable form.
+func printEstimate(res *estimateResult, params *chaincfg.Params) {
+	println("Consensus rules: %s    Policy Window: %d blocks",
+		res.ConsensusRules, res.PolicyWindow)
+	println("Tip Block: %d - %s (%s)", res.TipHeight, res.TipHash, res.Network)
+	println("Total Treasury Balance: %s", res.TotalBalance)
+	println("Policy Window Income: %s (treasury bases: %s, treasury adds: %s)",
+		res.TBaseAdded+res.TAddAdded, res.TBaseAdded, res.TAddAdded)
+	println("Policy Window Spent: %s", res.Spent)
+	println("Current Spendable Balance: %s", res.Spendable)
+	if len(res.MempoolTSpends) > 0 {
+		println("Current Spendable Balance (with mempool TSpends): %s",
+			res.WithMempool)
+	}
+
+	if len(res.TSpends) == 0 {
+		println("No tspends within policy window")
+	}
+	for _, ts := range res.TSpends {
+		timeToLeave := time.Duration(ts.BlocksToLeave) * params.TargetTimePerBlock
+		println("")
+		println("TSpend of %s on block %d (TSpend hash %s)",
+			ts.Amount, ts.MinedHeight, ts.Hash)
+		println("  Leaves policy window on block %d (%d %s, %s left)",
+			ts.LeaveHeight, ts.BlocksToLeave,
+			plural(ts.BlocksToLeave, "block", "blocks"),
+			formatDuration(timeToLeave))
+		println("  Estimated spendable after cleared: %s",
+			ts.SpendableAfter)
+		if ts.ConsensusRules != res.ConsensusRules {
+			println("  Consensus rules on block %d: %s",
+				ts.LeaveHeight, ts.ConsensusRules)
+		}
+		if len(res.MempoolTSpends) > 0 {
+			println("  Estimated spendable after cleared (with "+
+				"mempool TSpends): %s", ts.WithMempool)
+		}
+		if ts.BlocksToMaturity > 0 {
+			println("  NOTE: This TSpend is not yet reflected "+
+				"in the total treasury balance (%d %s "+
+				"to maturity)", ts.BlocksToMaturity,
+				plural(ts.BlocksToMaturity, "block", "blocks"),
+			)
+		}
+	}
+
+	for _, mts := range res.MempoolTSpends {
+		println("")
+		println("Mempool TSpend of %s (TSpend hash %s)", mts.Amount, mts.Hash)
+		println("  Voting interval: %d - %d   Votes Yes: %d No: %d",
+			mts.VoteStart, mts.VoteEnd, mts.YesVotes, mts.NoVotes)
+		println("  Modeled as mined on its next inclusion opportunity "+
+			"(block %d)", mts.InclusionHeight)
+	}
+
+	timeToExpiry := time.Duration(int64(res.NewTSpend.Expiry)-res.TipHeight) *
+		params.TargetTimePerBlock
 	println("")
-	println("Estimated new TSpend expiry: %d (%s from now)", expiry, formatDuration(timeToExpiry))
-	println("Estimated spendable amount at block %d: %s", endVoting, spendEstimate)
+	println("Estimated new TSpend expiry: %d (%s from now)",
+		res.NewTSpend.Expiry, formatDuration(timeToExpiry))
+	println("Estimated spendable amount at block %d: %s",
+		res.NewTSpend.VoteEnd, res.NewTSpend.Spendable)
+	if res.NewTSpend.ConsensusRules != res.ConsensusRules {
+		println("Consensus rules on block %d: %s", res.NewTSpend.VoteEnd,
+			res.NewTSpend.ConsensusRules)
+	}
+	if len(res.MempoolTSpends) > 0 {
+		println("Estimated spendable amount at block %d (with mempool "+
+			"TSpends): %s", res.NewTSpend.VoteEnd,
+			res.NewTSpend.WithMempool)
+	}
 	println("")
-	println("Note: estimation is solely based on treasury bases added to " +
-		"the treasury and does not account for any treasury adds or " +
-		"any new treasury spends included in the blockchain or " +
-		"currently in the mempool.")
+	println("Note: estimation is based on treasury bases added to the " +
+		"treasury and the treasury adds already in the policy window. " +
+		"It does not account for any future treasury adds or any new " +
+		"treasury spends that are not yet in the mempool.")
+}
 
-	return nil
+// writeJSON writes v as an indented JSON document to stdout.
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
 }
 
 // realMain is the real entrypoint for the app.
@@ -252,7 +277,83 @@ func realMain() error {
 			cfg.chainParams.Name, binfo.Chain)
 	}
 
-	return estimateSpend(ctx, c, cfg)
+	rules := loadConsensusRules(cfg, binfo)
+	if cfg.command == "history" {
+		res, err := treasuryHistory(ctx, c, cfg, rules)
+		if err != nil {
+			return err
+		}
+		if cfg.CSV != "" {
+			if err := writeHistoryCSV(cfg.CSV, res); err != nil {
+				return err
+			}
+		}
+		if cfg.JSON {
+			return writeJSON(res)
+		}
+		printHistory(res)
+		return nil
+	}
+
+	if cfg.command == "audit" {
+		res, err := auditTreasury(ctx, c, cfg, rules, binfo)
+		if err != nil {
+			return err
+		}
+		if cfg.JSON {
+			err = writeJSON(res)
+		} else {
+			printAudit(res)
+		}
+		if err == nil && len(res.Discrepancies) > 0 {
+			err = fmt.Errorf("found %d treasury %s",
+				len(res.Discrepancies), plural(int64(len(res.Discrepancies)),
+					"discrepancy", "discrepancies"))
+		}
+		return err
+	}
+
+	state, err := loadTreasuryState(ctx, c, cfg, rules)
+	if err != nil {
+		return err
+	}
+
+	switch cfg.command {
+	case "plan":
+		plan, err := loadPlan(state, cfg.PlanFile)
+		if err != nil {
+			return fmt.Errorf("unable to load plan: %v", err)
+		}
+		res := projectPlan(state, plan)
+		if cfg.JSON {
+			return writeJSON(res)
+		}
+		printPlan(res)
+		return nil
+
+	case "project":
+		res, err := projectAllowance(state, cfg.FromHeight, cfg.ToHeight, cfg.TVIs)
+		if err != nil {
+			return err
+		}
+		if cfg.CSV != "" {
+			if err := writeProjectionCSV(cfg.CSV, res); err != nil {
+				return err
+			}
+		}
+		if cfg.JSON {
+			return writeJSON(res)
+		}
+		printProjection(res)
+		return nil
+	}
+
+	res := estimateSpend(state)
+	if cfg.JSON {
+		return writeJSON(res)
+	}
+	printEstimate(res, cfg.chainParams)
+	return nil
 }
 
 func main() {
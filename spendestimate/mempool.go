@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+)
+
+// mempoolTSpend is a tspend waiting in the mempool, modeled as if it were
+// mined at its next inclusion opportunity.
+type mempoolTSpend struct {
+	Hash            chainhash.Hash `json:"hash"`
+	Amount          dcrutil.Amount `json:"amount"`
+	Expiry          uint32         `json:"expiry"`
+	VoteStart       uint32         `json:"votestart"`
+	VoteEnd         uint32         `json:"voteend"`
+	YesVotes        int64          `json:"yesvotes"`
+	NoVotes         int64          `json:"novotes"`
+	InclusionHeight uint32         `json:"inclusionheight"`
+}
+
+// nextInclusionHeight returns the next height after tipHeight where a tspend
+// with the given voting window could be included in a block. It returns zero
+// when the tspend can no longer be included.
+func nextInclusionHeight(params *chaincfg.Params, tipHeight int64, voteStart, voteEnd uint32) uint32 {
+	tvi := int64(params.TreasuryVoteInterval)
+	from := tipHeight
+	if int64(voteStart) > from {
+		from = int64(voteStart)
+	}
+	next := from + (tvi - from%tvi)
+	if next > int64(voteEnd) {
+		return 0
+	}
+	return uint32(next)
+}
+
+// fetchMempoolTSpends fetches the tspends currently in the mempool of the dcrd
+// instance that may still be included in a block after tipHeight.
+func fetchMempoolTSpends(ctx context.Context, c *rpcclient.Client,
+	params *chaincfg.Params, tipHeight int64) ([]mempoolTSpend, error) {
+
+	votes, err := c.GetTreasurySpendVotes(ctx, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch mempool tspends: %v", err)
+	}
+
+	res := make([]mempoolTSpend, 0, len(votes.Votes))
+	for _, v := range votes.Votes {
+		hash, err := chainhash.NewHashFromStr(v.Hash)
+		if err != nil {
+			return nil, err
+		}
+		inclusion := nextInclusionHeight(params, tipHeight,
+			uint32(v.VoteStart), uint32(v.VoteEnd))
+		if inclusion == 0 {
+			continue
+		}
+
+		tx, err := c.GetRawTransaction(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch mempool tspend "+
+				"%s: %v", hash, err)
+		}
+		if !stake.IsTSpend(tx.MsgTx()) {
+			return nil, fmt.Errorf("mempool tx %s is not a tspend", hash)
+		}
+
+		res = append(res, mempoolTSpend{
+			Hash:            *hash,
+			Amount:          dcrutil.Amount(tx.MsgTx().TxIn[0].ValueIn),
+			Expiry:          uint32(v.Expiry),
+			VoteStart:       uint32(v.VoteStart),
+			VoteEnd:         uint32(v.VoteEnd),
+			YesVotes:        v.YesVotes,
+			NoVotes:         v.NoVotes,
+			InclusionHeight: inclusion,
+		})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// plannedTSpend is a tspend planned for the future.
+type plannedTSpend struct {
+	Label           string         `json:"label"`
+	Amount          dcrutil.Amount `json:"amount"`
+	Expiry          uint32         `json:"expiry"`
+	VoteStart       uint32         `json:"votestart"`
+	VoteEnd         uint32         `json:"voteend"`
+	InclusionHeight int64          `json:"inclusionheight"`
+}
+
+// loadPlan loads the planned tspends from a CSV file. Each record is in the
+// form:
+//
+//	<amount in DCR>,<expiry|tvi>,<height>[,<label>]
+//
+// When the target is an expiry, the tspend is modeled as included at the end
+// of its voting window. When the target is a TVI, the tspend is modeled as
+// included at that TVI, using the expiry whose voting window ends at it.
+func loadPlan(s *treasuryState, path string) ([]plannedTSpend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tvi, mul := uint64(s.tvi), uint64(s.mul)
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	var plan []plannedTSpend
+	for i := 0; ; i++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 || len(record) > 4 {
+			return nil, fmt.Errorf("record %d does not have 3 or 4 "+
+				"elements (%d)", i, len(record))
+		}
+
+		amtFloat, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("record %d[0] is not a float: %v",
+				i, err)
+		}
+		amt, err := dcrutil.NewAmount(amtFloat)
+		if err != nil {
+			return nil, fmt.Errorf("record %d[0] is not a dcr "+
+				"amount: %v", i, err)
+		}
+		target, err := strconv.ParseUint(strings.TrimSpace(record[2]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("record %d[2] is not a height: %v",
+				i, err)
+		}
+
+		pts := plannedTSpend{
+			Label:  fmt.Sprintf("#%d", i),
+			Amount: amt,
+		}
+		if len(record) > 3 {
+			pts.Label = strings.TrimSpace(record[3])
+		}
+		switch strings.TrimSpace(record[1]) {
+		case "expiry":
+			pts.Expiry = uint32(target)
+		case "tvi":
+			if !standalone.IsTreasuryVoteInterval(target, tvi) {
+				return nil, fmt.Errorf("record %d[2] is not a TVI "+
+					"height", i)
+			}
+			pts.Expiry = uint32(target + 2)
+		default:
+			return nil, fmt.Errorf("record %d[1] is not either "+
+				"'expiry' or 'tvi'", i)
+		}
+		pts.VoteStart, pts.VoteEnd, err = standalone.CalcTSpendWindow(
+			pts.Expiry, tvi, mul)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", i, err)
+		}
+		pts.InclusionHeight = int64(pts.VoteEnd)
+		if pts.InclusionHeight <= s.tipHeight {
+			return nil, fmt.Errorf("record %d: inclusion height %d "+
+				"is not after the tip", i, pts.InclusionHeight)
+		}
+		plan = append(plan, pts)
+	}
+
+	// Process in inclusion order.
+	sort.SliceStable(plan, func(i, j int) bool {
+		return plan[i].InclusionHeight < plan[j].InclusionHeight
+	})
+	return plan, nil
+}
+
+// planProjection is the projection for a single planned tspend.
+type planProjection struct {
+	plannedTSpend
+	InclusionTime   time.Time      `json:"inclusiontime"`
+	Allowance       dcrutil.Amount `json:"allowance"`
+	AllowanceAfter  dcrutil.Amount `json:"allowanceafter"`
+	Feasible        bool           `json:"feasible"`
+	SuggestedHeight int64          `json:"suggestedinclusionheight,omitempty"`
+	SuggestedExpiry uint32         `json:"suggestedexpiry,omitempty"`
+	ConsensusRules  string         `json:"consensusrules"`
+}
+
+// planResult is the result of projecting a plan.
+type planResult struct {
+	Network      string           `json:"network"`
+	TipHeight    int64            `json:"tipheight"`
+	PolicyWindow int64            `json:"policywindow"`
+	Projections  []planProjection `json:"projections"`
+}
+
+// plannedAt returns the sum of the planned tspends (other than skip) in the
+// policy window ending at the given height.
+func plannedAt(s *treasuryState, plan []plannedTSpend, height int64, skip int) dcrutil.Amount {
+	var sum dcrutil.Amount
+	for i, pts := range plan {
+		if i != skip && inPolicyWindow(pts.InclusionHeight, height, s.policyWindow) {
+			sum += pts.Amount
+		}
+	}
+	return sum
+}
+
+// projectPlan projects the spendable allowance after mined history, mempool
+// tspends and each planned tspend, flagging the planned tspends that would
+// exceed the expenditure policy at their inclusion height.
+func projectPlan(s *treasuryState, plan []plannedTSpend) *planResult {
+	res := &planResult{
+		Network:      s.params.Name,
+		TipHeight:    s.tipHeight,
+		PolicyWindow: s.policyWindow,
+		Projections:  make([]planProjection, 0, len(plan)),
+	}
+
+	for i, pts := range plan {
+		// Only planned tspends included before this one (or listed
+		// before it, on the same block) count against its allowance.
+		h := pts.InclusionHeight
+		allowance := s.allowanceAt(h, true) - plannedAt(s, plan[:i], h, -1)
+		proj := planProjection{
+			plannedTSpend:  pts,
+			InclusionTime:  s.heightTime(h),
+			Allowance:      allowance,
+			AllowanceAfter: allowance - pts.Amount,
+			Feasible:       pts.Amount <= allowance,
+			ConsensusRules: s.rules.At(h),
+		}
+
+		// Look for the earliest TVI where it would fit, considering
+		// all other planned tspends stay where they are. After a full
+		// policy window has elapsed past the last planned tspend,
+		// nothing but the amount of treasury bases can change. Windows
+		// that already started at the tip cannot be voted on in full,
+		// so they are skipped.
+		lastHeight := plan[len(plan)-1].InclusionHeight
+		maxHeight := lastHeight + s.policyWindow + s.tvi
+		for nh := h + s.tvi; !proj.Feasible && nh <= maxHeight; nh += s.tvi {
+			start, _, err := standalone.CalcTSpendWindow(uint32(nh+2),
+				uint64(s.tvi), s.params.TreasuryVoteIntervalMultiplier)
+			if err != nil || int64(start) <= s.tipHeight {
+				continue
+			}
+			nallowance := s.allowanceAt(nh, true) - plannedAt(s, plan, nh, i)
+			if pts.Amount <= nallowance {
+				proj.SuggestedHeight = nh
+				proj.SuggestedExpiry = uint32(nh + 2)
+				break
+			}
+		}
+
+		res.Projections = append(res.Projections, proj)
+	}
+
+	return res
+}
+
+// printPlan prints the plan projection in human readable form.
+func printPlan(res *planResult) {
+	println("Tip Block: %d (%s)    Policy Window: %d blocks", res.TipHeight,
+		res.Network, res.PolicyWindow)
+	if len(res.Projections) == 0 {
+		println("No planned tspends")
+		return
+	}
+
+	var infeasible int
+	var lastEnd uint32
+	for _, p := range res.Projections {
+		if p.VoteEnd != lastEnd {
+			println("")
+			println("Voting window %d - %d (expiry %d, est. %s, %s rules)",
+				p.VoteStart, p.VoteEnd, p.Expiry,
+				p.InclusionTime.UTC().Format("2006-01-02 15:04 MST"),
+				p.ConsensusRules)
+			lastEnd = p.VoteEnd
+		}
+		status := "OK"
+		if !p.Feasible {
+			status = "EXCEEDS LIMIT"
+			infeasible++
+		}
+		println("  %-20s %16s   allowance %16s   after %16s   %s",
+			p.Label, p.Amount, p.Allowance, p.AllowanceAfter, status)
+		if p.Feasible {
+			continue
+		}
+		if p.SuggestedHeight != 0 {
+			println("    Earliest feasible inclusion: block %d "+
+				"(expiry %d)", p.SuggestedHeight, p.SuggestedExpiry)
+		} else {
+			println("    No feasible inclusion found")
+		}
+	}
+
+	println("")
+	if infeasible > 0 {
+		println("%d planned %s would exceed the expenditure policy",
+			infeasible, plural(int64(infeasible), "tspend", "tspends"))
+	} else {
+		println("All planned tspends fit the expenditure policy")
+	}
+}
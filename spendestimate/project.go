@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// projectionRow is the projected allowance at a single TVI.
+type projectionRow struct {
+	Height            int64          `json:"height"`
+	Time              time.Time      `json:"time"`
+	TBase             dcrutil.Amount `json:"tbase"`
+	TBaseSum          dcrutil.Amount `json:"tbasesum"`
+	TAddSum           dcrutil.Amount `json:"taddsum"`
+	MinedSpends       dcrutil.Amount `json:"minedspends"`
+	MempoolSpends     dcrutil.Amount `json:"mempoolspends"`
+	Allowance         dcrutil.Amount `json:"allowance"`
+	WithMempool       dcrutil.Amount `json:"allowancewithmempool"`
+	SubsidyReduction  bool           `json:"subsidyreduction"`
+	ReductionInWindow bool           `json:"reductioninwindow"`
+	ConsensusRules    string         `json:"consensusrules"`
+}
+
+// projectionResult is the baseline projection of the spendable allowance.
+type projectionResult struct {
+	Network      string          `json:"network"`
+	TipHeight    int64           `json:"tipheight"`
+	PolicyWindow int64           `json:"policywindow"`
+	Rows         []projectionRow `json:"rows"`
+}
+
+// projectAllowance projects the spendable allowance at every TVI in the range
+// [fromHeight, toHeight]. When both are zero, the next nbTVIs TVIs after the
+// tip are projected.
+func projectAllowance(s *treasuryState, fromHeight, toHeight int64, nbTVIs int) (*projectionResult, error) {
+	if fromHeight == 0 && toHeight == 0 {
+		fromHeight = s.tipHeight + 1
+		toHeight = s.tipHeight + s.tvi*int64(nbTVIs)
+	}
+	if toHeight < fromHeight {
+		return nil, fmt.Errorf("toheight (%d) must not be lower than "+
+			"fromheight (%d)", toHeight, fromHeight)
+	}
+
+	// Round up to the first TVI in the range.
+	height := fromHeight
+	if height%s.tvi != 0 {
+		height += s.tvi - height%s.tvi
+	}
+
+	res := &projectionResult{
+		Network:      s.params.Name,
+		TipHeight:    s.tipHeight,
+		PolicyWindow: s.policyWindow,
+		Rows:         []projectionRow{},
+	}
+	sri := s.params.SubsidyReductionInterval
+	prevHeight := height - s.tvi
+	for ; height <= toHeight; height += s.tvi {
+		allowance := s.allowanceAt(height, false)
+		row := projectionRow{
+			Height:        height,
+			Time:          s.heightTime(height),
+			TBase:         s.tbaseAt(height),
+			TBaseSum:      s.tbasesAt(height),
+			TAddSum:       s.taddsAt(height),
+			MinedSpends:   s.minedAt(height),
+			MempoolSpends: s.mempoolAt(height),
+			Allowance:     allowance,
+			WithMempool:   allowance - s.mempoolAt(height),
+
+			// A subsidy reduction happened since the previous row
+			// or is still inside the policy window.
+			SubsidyReduction:  height/sri != prevHeight/sri,
+			ReductionInWindow: height/sri != (height-s.policyWindow)/sri,
+			ConsensusRules:    s.rules.At(height),
+		}
+		res.Rows = append(res.Rows, row)
+		prevHeight = height
+	}
+
+	return res, nil
+}
+
+// printProjection prints the projection in human readable form.
+func printProjection(res *projectionResult) {
+	println("Tip Block: %d (%s)    Policy Window: %d blocks", res.TipHeight,
+		res.Network, res.PolicyWindow)
+	println("")
+	println("%8s  %-16s  %-7s  %16s  %16s  %16s  %16s  %16s  %s", "Height",
+		"Est. Time", "Rules", "TBase Sum", "TAdd Sum", "In-Window Spends",
+		"Allowance", "With Mempool", "Notes")
+	for _, r := range res.Rows {
+		notes := ""
+		if r.SubsidyReduction {
+			notes = "subsidy reduction"
+		}
+		println("%8d  %-16s  %-7s  %16s  %16s  %16s  %16s  %16s  %s", r.Height,
+			r.Time.UTC().Format("2006-01-02 15:04"), r.ConsensusRules,
+			r.TBaseSum, r.TAddSum, r.MinedSpends+r.MempoolSpends,
+			r.Allowance, r.WithMempool, notes)
+	}
+}
+
+// writeProjectionCSV writes the projection as a CSV file. Amounts are in
+// atoms.
+func writeProjectionCSV(path string, res *projectionResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating csv file: %v", err)
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"height", "time", "tbase", "tbase_sum", "tadd_sum",
+		"mined_spends", "mempool_spends", "allowance",
+		"allowance_with_mempool", "subsidy_reduction",
+		"reduction_in_window", "consensus_rules"})
+	itoa := func(a dcrutil.Amount) string {
+		return strconv.FormatInt(int64(a), 10)
+	}
+	for _, r := range res.Rows {
+		w.Write([]string{
+			strconv.FormatInt(r.Height, 10),
+			r.Time.UTC().Format(time.RFC3339),
+			itoa(r.TBase),
+			itoa(r.TBaseSum),
+			itoa(r.TAddSum),
+			itoa(r.MinedSpends),
+			itoa(r.MempoolSpends),
+			itoa(r.Allowance),
+			itoa(r.WithMempool),
+			strconv.FormatBool(r.SubsidyReduction),
+			strconv.FormatBool(r.ReductionInWindow),
+			r.ConsensusRules,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
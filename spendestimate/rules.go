@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	chainjson "github.com/decred/dcrd/rpc/jsonrpc/types/v4"
+	"github.com/matheusd/tspend/internal/treasury"
+)
+
+// loadConsensusRules determines the treasury rules from the deployment status
+// reported by dcrd, unless overridden in the config.
+func loadConsensusRules(cfg *config, binfo *chainjson.GetBlockChainInfoResult) treasury.ConsensusRules {
+	rules := treasury.LoadConsensusRules(binfo, cfg.chainParams)
+	if cfg.TreasuryHeight != 0 {
+		rules.TreasuryHeight = cfg.TreasuryHeight
+	}
+	if cfg.DCP0007Height != 0 {
+		rules.DCP0007Height = cfg.DCP0007Height
+	}
+	return rules
+}
+
+// activationHeight returns the height the treasury was activated at or an
+// error if it is not active.
+func activationHeight(rules treasury.ConsensusRules) (int64, error) {
+	if rules.TreasuryHeight < 0 {
+		return 0, fmt.Errorf("treasury agenda is not active; " +
+			"specify --fromheight or --treasuryheight")
+	}
+	return rules.TreasuryHeight, nil
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/matheusd/tspend/internal/treasury"
+)
+
+type tspend struct {
+	hash        chainhash.Hash
+	minedHash   chainhash.Hash
+	minedHeight uint32
+	minedTime   time.Time
+	amount      dcrutil.Amount
+	payouts     int
+	piKey       []byte
+}
+
+// treasuryAdd is a treasury add (TADD) mined in a block.
+type treasuryAdd struct {
+	minedHeight uint32
+	amount      dcrutil.Amount
+}
+
+// treasuryChanges are the changes to the treasury found in a range of blocks.
+type treasuryChanges struct {
+	tbaseAdded     dcrutil.Amount
+	taddAdded      dcrutil.Amount
+	spent          dcrutil.Amount
+	initialBalance dcrutil.Amount
+	finalBalance   dcrutil.Amount
+	tspends        []tspend
+	tadds          []treasuryAdd
+}
+
+// added returns the total amount added to the treasury.
+func (tc *treasuryChanges) added() dcrutil.Amount {
+	return tc.tbaseAdded + tc.taddAdded
+}
+
+// blockCacheVersion is the version of the block cache format. Caches written
+// with a different version are discarded.
+const blockCacheVersion = 1
+
+// cachedTSpend is a tspend stored in the block cache.
+type cachedTSpend struct {
+	Hash    chainhash.Hash
+	Amount  int64
+	Payouts int
+	PiKey   []byte
+}
+
+// cachedBlock are the treasury changes of a single block. Once fetched, these
+// never change for a given block hash.
+type cachedBlock struct {
+	Height  uint32
+	Balance int64
+	Updates []int64
+	TSpends []cachedTSpend
+
+	// Time is the block timestamp (in unix seconds). It is only recorded
+	// for blocks with tspends, which are the only ones fetched in full.
+	Time int64
+
+	// Expected are the updates recomputed from the stake transactions of
+	// the block, which are only known (and Audited set) once the audit
+	// command fetched the block in full.
+	Expected []int64
+	Audited  bool
+}
+
+// blockCache is a persistent cache of per-block treasury changes, keyed by
+// block hash. It also tracks the hash of each main chain height as last seen.
+// Every recorded height is an ancestor of (or is) the recorded tip, so checking
+// that the tip is still in the main chain validates all of them.
+type blockCache struct {
+	path string
+
+	mtx       sync.Mutex
+	Version   int
+	Blocks    map[chainhash.Hash]*cachedBlock
+	Heights   map[uint32]chainhash.Hash
+	TipHeight uint32
+	TipHash   chainhash.Hash
+}
+
+// loadBlockCache loads the block cache from the given path. An empty path
+// returns a cache that is never persisted. A missing or corrupted cache file
+// is not an error: an empty cache is returned instead.
+func loadBlockCache(path string) *blockCache {
+	bc := &blockCache{
+		path:    path,
+		Version: blockCacheVersion,
+		Blocks:  make(map[chainhash.Hash]*cachedBlock),
+		Heights: make(map[uint32]chainhash.Hash),
+	}
+	if path == "" {
+		return bc
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return bc
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(bc); err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring corrupted block cache %s: %v\n",
+			path, err)
+		bc.Blocks = make(map[chainhash.Hash]*cachedBlock)
+		bc.Heights = make(map[uint32]chainhash.Hash)
+	}
+	if bc.Version != blockCacheVersion {
+		bc.Version = blockCacheVersion
+		bc.Blocks = make(map[chainhash.Hash]*cachedBlock)
+		bc.Heights = make(map[uint32]chainhash.Hash)
+	}
+	return bc
+}
+
+// save persists the cache to disk.
+func (bc *blockCache) save() error {
+	if bc.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(bc.path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temp file first so that an interrupted write does not
+	// corrupt an existing cache.
+	tmpPath := bc.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	bc.mtx.Lock()
+	err = gob.NewEncoder(f).Encode(bc)
+	bc.mtx.Unlock()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, bc.path)
+}
+
+func (bc *blockCache) block(hash chainhash.Hash) *cachedBlock {
+	bc.mtx.Lock()
+	b := bc.Blocks[hash]
+	bc.mtx.Unlock()
+	return b
+}
+
+func (bc *blockCache) heightHash(height uint32) (chainhash.Hash, bool) {
+	bc.mtx.Lock()
+	hash, ok := bc.Heights[height]
+	bc.mtx.Unlock()
+	return hash, ok
+}
+
+func (bc *blockCache) put(hash chainhash.Hash, b *cachedBlock) {
+	bc.mtx.Lock()
+	bc.Blocks[hash] = b
+	bc.Heights[b.Height] = hash
+	bc.mtx.Unlock()
+}
+
+// validateHeights drops the recorded heights that are no longer in the main
+// chain. Since they are all ancestors of the recorded tip, heights are walked
+// back from it until one matches the main chain: every height below the match
+// is unchanged and every height above it was reorged out.
+func (bc *blockCache) validateHeights(ctx context.Context, c *rpcclient.Client) error {
+	bc.mtx.Lock()
+	defer bc.mtx.Unlock()
+	if bc.TipHash == (chainhash.Hash{}) {
+		return nil
+	}
+	_, bestHeight, err := c.GetBestBlock(ctx)
+	if err != nil {
+		return err
+	}
+	for h := int64(bc.TipHeight); h >= 0; h-- {
+		cached, ok := bc.Heights[uint32(h)]
+		if !ok {
+			continue
+		}
+		if h <= bestHeight {
+			hash, err := c.GetBlockHash(ctx, h)
+			if err != nil {
+				return err
+			}
+			if *hash == cached {
+				bc.TipHeight, bc.TipHash = uint32(h), cached
+				return nil
+			}
+		}
+		delete(bc.Heights, uint32(h))
+	}
+	bc.TipHeight, bc.TipHash = 0, chainhash.Hash{}
+	return nil
+}
+
+// setTip records the main chain block at the given height as the tip of the
+// recorded heights, unless a higher one is already recorded.
+func (bc *blockCache) setTip(hash chainhash.Hash, height uint32) {
+	bc.mtx.Lock()
+	if bc.TipHash == (chainhash.Hash{}) || height >= bc.TipHeight {
+		bc.TipHeight, bc.TipHash = height, hash
+	}
+	bc.mtx.Unlock()
+}
+
+// fetchBlock fetches the treasury changes of the given block from dcrd.
+func fetchBlock(ctx context.Context, c *rpcclient.Client, hash *chainhash.Hash) (*cachedBlock, error) {
+	tbalance, err := c.GetTreasuryBalance(ctx, hash, true)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &cachedBlock{
+		Height:  uint32(tbalance.Height),
+		Balance: int64(tbalance.Balance),
+		Updates: tbalance.Updates,
+	}
+
+	// Each tspend generates one negative update for its payouts and
+	// another one for its fee, so compare the totals instead of the number
+	// of updates.
+	var spent int64
+	for _, v := range tbalance.Updates {
+		if v < 0 {
+			spent += -v
+		}
+	}
+	if spent == 0 {
+		return b, nil
+	}
+
+	// Block has TSpends. Fetch the block and find them.
+	block, err := c.GetBlock(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	b.Time = block.Header.Timestamp.Unix()
+	var found int64
+	for _, tx := range block.STransactions {
+		if !stake.IsTSpend(tx) {
+			continue
+		}
+		_, piKey, err := stake.CheckTSpend(tx)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tspend %s in block %s: %v",
+				tx.TxHash(), hash, err)
+		}
+		b.TSpends = append(b.TSpends, cachedTSpend{
+			Hash:    tx.TxHash(),
+			Amount:  tx.TxIn[0].ValueIn,
+			Payouts: len(tx.TxOut) - 1,
+			PiKey:   piKey,
+		})
+		found += tx.TxIn[0].ValueIn
+	}
+	if found != spent {
+		return nil, fmt.Errorf("found tspends totalling %s while "+
+			"expected %s in block %s", dcrutil.Amount(found),
+			dcrutil.Amount(spent), hash)
+	}
+	return b, nil
+}
+
+// resolveHashes returns the main chain hashes of the heights in the range
+// [startHeight, tipHeight], where tipHash is the hash of the block at
+// tipHeight.
+//
+// The heights recorded in the cache are first validated against the main
+// chain, which only requires walking back from the recorded tip past any
+// reorged blocks. Validated heights are then reused and heights never seen
+// before are fetched in parallel.
+func resolveHashes(ctx context.Context, c *rpcclient.Client, bc *blockCache,
+	tipHash chainhash.Hash, tipHeight, startHeight uint32, workers int) ([]chainhash.Hash, error) {
+
+	if err := bc.validateHeights(ctx, c); err != nil {
+		return nil, err
+	}
+
+	hashes := make([]chainhash.Hash, tipHeight-startHeight+1)
+	hashes[len(hashes)-1] = tipHash
+	var missing []uint32
+	for h := startHeight; h < tipHeight; h++ {
+		if cached, ok := bc.heightHash(h); ok {
+			hashes[h-startHeight] = cached
+		} else {
+			missing = append(missing, h)
+		}
+	}
+	err := treasury.RunChunked(ctx, missing, workers, func(h uint32) error {
+		hash, err := c.GetBlockHash(ctx, int64(h))
+		if err != nil {
+			return err
+		}
+		hashes[h-startHeight] = *hash
+		return nil
+	})
+	return hashes, err
+}
+
+// scanBlocks returns the treasury changes of each block in the range of
+// nbBlocks ending at node, in increasing height order. Blocks are fetched from
+// dcrd by a bounded pool of concurrent workers unless they are already in the
+// cache.
+func scanBlocks(ctx context.Context, c *rpcclient.Client, bc *blockCache,
+	node chainhash.Hash, nbBlocks uint, workers int) ([]chainhash.Hash, []*cachedBlock, error) {
+
+	header, err := c.GetBlockHeader(ctx, &node)
+	if err != nil {
+		return nil, nil, err
+	}
+	if nbBlocks == 0 {
+		return nil, nil, nil
+	}
+	tipHeight := header.Height
+	startHeight := uint32(0)
+	if uint(tipHeight)+1 > nbBlocks {
+		startHeight = tipHeight + 1 - uint32(nbBlocks)
+	}
+
+	hashes, err := resolveHashes(ctx, c, bc, node, tipHeight, startHeight, workers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blocks := make([]*cachedBlock, len(hashes))
+	var missing []uint32
+	for i, hash := range hashes {
+		blocks[i] = bc.block(hash)
+		if blocks[i] == nil {
+			missing = append(missing, startHeight+uint32(i))
+		}
+	}
+	if len(missing) > treasury.ScanChunkSize {
+		fmt.Fprintf(os.Stderr, "Fetching treasury changes of %d blocks\n",
+			len(missing))
+	}
+	err = treasury.RunChunked(ctx, missing, workers, func(h uint32) error {
+		i := h - startHeight
+		b, err := fetchBlock(ctx, c, &hashes[i])
+		if err != nil {
+			return err
+		}
+		if b.Height != h {
+			return fmt.Errorf("block %s reported height %d while "+
+				"expected %d", hashes[i], b.Height, h)
+		}
+		bc.put(hashes[i], b)
+		blocks[i] = b
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Record the (possibly reorged) main chain.
+	for i, hash := range hashes {
+		bc.put(hash, blocks[i])
+	}
+	bc.setTip(node, tipHeight)
+
+	return hashes, blocks, nil
+}
+
+// treasuryChangesFromBlocks sums up the treasury changes in the given blocks,
+// which must be in increasing height order.
+func treasuryChangesFromBlocks(hashes []chainhash.Hash, blocks []*cachedBlock) treasuryChanges {
+	var tc treasuryChanges
+	if len(blocks) == 0 {
+		return tc
+	}
+	tc.initialBalance = dcrutil.Amount(blocks[0].Balance)
+	tc.finalBalance = dcrutil.Amount(blocks[len(blocks)-1].Balance)
+
+	// Process in decreasing height order, which is the order tspends have
+	// always been returned in.
+	for i := len(blocks) - 1; i >= 0; i-- {
+		b := blocks[i]
+
+		// Updates are listed in the order of the stake transactions
+		// of the block, therefore the first one is the treasurybase
+		// and any other positive update is a treasury add.
+		for j, v := range b.Updates {
+			switch {
+			case v > 0 && j == 0:
+				tc.tbaseAdded += dcrutil.Amount(v)
+			case v > 0:
+				tc.taddAdded += dcrutil.Amount(v)
+				tc.tadds = append(tc.tadds, treasuryAdd{
+					minedHeight: b.Height,
+					amount:      dcrutil.Amount(v),
+				})
+			case v < 0:
+				tc.spent += -dcrutil.Amount(v)
+			}
+		}
+
+		for _, ts := range b.TSpends {
+			tc.tspends = append(tc.tspends, tspend{
+				hash:        ts.Hash,
+				minedHash:   hashes[i],
+				minedHeight: b.Height,
+				minedTime:   time.Unix(b.Time, 0),
+				amount:      dcrutil.Amount(ts.Amount),
+				payouts:     ts.Payouts,
+				piKey:       ts.PiKey,
+			})
+		}
+	}
+
+	return tc
+}
+
+// scanAndSaveBlocks is scanBlocks followed by persisting the block cache,
+// which is saved even on errors to keep whatever was already fetched.
+func scanAndSaveBlocks(ctx context.Context, c *rpcclient.Client, bc *blockCache,
+	node chainhash.Hash, nbBlocks uint, workers int) ([]chainhash.Hash, []*cachedBlock, error) {
+
+	hashes, blocks, err := scanBlocks(ctx, c, bc, node, nbBlocks, workers)
+	if saveErr := bc.save(); saveErr != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "Unable to save block cache: %v\n", saveErr)
+	}
+	return hashes, blocks, err
+}
+
+// pastTreasuryChanges returns the treasury changes in the nbBlocks blocks
+// ending at node (inclusive).
+func pastTreasuryChanges(ctx context.Context, c *rpcclient.Client, bc *blockCache,
+	node chainhash.Hash, nbBlocks uint, workers int) (treasuryChanges, error) {
+
+	hashes, blocks, err := scanAndSaveBlocks(ctx, c, bc, node, nbBlocks, workers)
+	if err != nil {
+		return treasuryChanges{}, err
+	}
+	return treasuryChangesFromBlocks(hashes, blocks), nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/matheusd/tspend/internal/treasury"
+)
+
+// treasuryState is the state of the treasury at a given tip, as needed to
+// estimate the spendable amounts at future heights.
+type treasuryState struct {
+	params       *chaincfg.Params
+	policy       *treasury.Policy
+	tvi          int64
+	mul          int64
+	policyWindow int64
+	rules        treasury.ConsensusRules
+
+	tipHeight int64
+	tipHash   chainhash.Hash
+	tipTime   time.Time
+
+	// changes are the treasury changes in the policy window ending at the
+	// tip. Its tspends are sorted by increasing height.
+	changes treasuryChanges
+
+	// mempool are the tspends in the mempool that may still be mined.
+	mempool []mempoolTSpend
+
+	// priorTSpends are the tspends mined in the policy windows preceding
+	// the tip, as needed by the DCP0006 expenditure policy. It is only
+	// filled when that policy is still in effect at the tip.
+	priorTSpends []tspend
+}
+
+// loadTreasuryState fetches the state of the treasury at the tip (or at the
+// height specified in the config).
+func loadTreasuryState(ctx context.Context, c *rpcclient.Client, cfg *config,
+	rules treasury.ConsensusRules) (*treasuryState, error) {
+
+	tipHash, tipHeight, err := c.GetBestBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Height != 0 {
+		tipHeight = int64(cfg.Height)
+		tipHash, err = c.GetBlockHash(ctx, tipHeight)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tipHeader, err := c.GetBlockHeader(ctx, tipHash)
+	if err != nil {
+		return nil, err
+	}
+
+	params := cfg.chainParams
+	tvi := int64(params.TreasuryVoteInterval)
+	mul := int64(params.TreasuryVoteIntervalMultiplier)
+	policy := treasury.NewPolicy(params, rules)
+	s := &treasuryState{
+		params:       params,
+		policy:       policy,
+		tvi:          tvi,
+		mul:          mul,
+		policyWindow: policy.Window,
+		rules:        rules,
+		tipHeight:    tipHeight,
+		tipHash:      *tipHash,
+		tipTime:      tipHeader.Timestamp,
+	}
+
+	// Fetch the treasury changes from the tip height for the past
+	// expenditure policy window.
+	bc := loadBlockCache(cfg.blockCachePath())
+	s.changes, err = pastTreasuryChanges(ctx, c, bc, *tipHash,
+		uint(s.policyWindow), cfg.Workers)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch past treasury changes: %v", err)
+	}
+
+	// Sort tspends by increasing height.
+	tspends := s.changes.tspends
+	sort.Slice(tspends, func(i, j int) bool {
+		return tspends[i].minedHeight < tspends[j].minedHeight
+	})
+
+	// The DCP0006 policy depends on the amounts spent in the previous
+	// policy windows, so fetch those as well while it is in effect.
+	if rules.At(tipHeight) != treasury.RulesDCP0007 {
+		nbWindows := int64(params.TreasuryExpenditurePolicy) + 1
+		prior, err := pastTreasuryChanges(ctx, c, bc, *tipHash,
+			uint(s.policyWindow*nbWindows), cfg.Workers)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch prior treasury "+
+				"changes: %v", err)
+		}
+		s.priorTSpends = prior.tspends
+	}
+
+	// Fetch the tspends that may still be mined from the mempool. These
+	// only make sense when estimating from the current tip.
+	if cfg.Height == 0 {
+		s.mempool, err = fetchMempoolTSpends(ctx, c, params, tipHeight)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// heightTime returns the estimated time of the given height.
+func (s *treasuryState) heightTime(height int64) time.Time {
+	return s.tipTime.Add(time.Duration(height-s.tipHeight) *
+		s.params.TargetTimePerBlock)
+}
+
+// tbasesAt returns the estimated sum of treasury bases in the policy window
+// ending at the given height.
+func (s *treasuryState) tbasesAt(height int64) dcrutil.Amount {
+	return s.policy.TreasuryBases(height)
+}
+
+// tbaseAt returns the treasury base of the given height.
+func (s *treasuryState) tbaseAt(height int64) dcrutil.Amount {
+	return s.policy.TreasuryBase(height)
+}
+
+// taddsAt returns the sum of the known treasury adds still in the policy
+// window ending at the given height.
+func (s *treasuryState) taddsAt(height int64) dcrutil.Amount {
+	var sum dcrutil.Amount
+	for _, ta := range s.changes.tadds {
+		if inPolicyWindow(int64(ta.minedHeight), height, s.policyWindow) {
+			sum += ta.amount
+		}
+	}
+	return sum
+}
+
+// minedAt returns the sum of the mined tspends still in the policy window
+// ending at the given height.
+func (s *treasuryState) minedAt(height int64) dcrutil.Amount {
+	var sum dcrutil.Amount
+	for _, ts := range s.changes.tspends {
+		if inPolicyWindow(int64(ts.minedHeight), height, s.policyWindow) {
+			sum += ts.amount
+		}
+	}
+	return sum
+}
+
+// mempoolAt returns the sum of the mempool tspends that are modeled as mined
+// in the policy window ending at the given height.
+func (s *treasuryState) mempoolAt(height int64) dcrutil.Amount {
+	var sum dcrutil.Amount
+	for _, mts := range s.mempool {
+		if inPolicyWindow(int64(mts.InclusionHeight), height, s.policyWindow) {
+			sum += mts.Amount
+		}
+	}
+	return sum
+}
+
+// policyLimitAt returns the estimated maximum expenditure of the policy window
+// ending at the given height, according to the rules in effect at that height.
+func (s *treasuryState) policyLimitAt(height int64) dcrutil.Amount {
+	income := func(end int64) dcrutil.Amount {
+		return s.tbasesAt(end) + s.taddsAt(end)
+	}
+	spent := func(end int64) dcrutil.Amount {
+		var sum dcrutil.Amount
+		for _, ts := range s.priorTSpends {
+			if inPolicyWindow(int64(ts.minedHeight), end, s.policyWindow) {
+				sum += ts.amount
+			}
+		}
+		return sum
+	}
+	return s.policy.Limit(height, income, spent)
+}
+
+// allowanceAt returns the estimated maximum expenditure allowed for a tspend
+// included at the given height, after accounting for the tspends mined (and
+// optionally the ones in the mempool) in its policy window.
+func (s *treasuryState) allowanceAt(height int64, withMempool bool) dcrutil.Amount {
+	allowance := s.policyLimitAt(height) - s.minedAt(height)
+	if withMempool {
+		allowance -= s.mempoolAt(height)
+	}
+	return allowance
+}
+
+// newTSpendExpiry returns the expiry that would be used by a tspend generated
+// at the tip.
+func (s *treasuryState) newTSpendExpiry() uint32 {
+	tvi, mul := s.tvi, s.mul
+	blocksToTVI := tvi - (s.tipHeight % tvi)
+	tooCloseThresh := tvi / 4
+	isTooClose := blocksToTVI < tooCloseThresh
+	expiry := standalone.CalcTSpendExpiry(s.tipHeight, uint64(tvi), uint64(mul))
+
+	if isTooClose {
+		// Advance to following TVI.
+		expiry = standalone.CalcTSpendExpiry(s.tipHeight+blocksToTVI, uint64(tvi), uint64(mul))
+	}
+	return expiry
+}
@@ -5,24 +5,20 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
-	"encoding/binary"
 	"encoding/csv"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 	"strings"
 
 	"github.com/davecgh/go-spew/spew"
-	"github.com/decred/dcrd/blockchain/stake/v5"
 	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
 	"github.com/decred/dcrd/chaincfg/chainhash"
-	"github.com/decred/dcrd/crypto/blake256"
+	"github.com/decred/dcrd/chaincfg/v3"
 	"github.com/decred/dcrd/dcrjson/v4"
 	"github.com/decred/dcrd/dcrutil/v4"
 	"github.com/decred/dcrd/rpcclient/v8"
-	"github.com/decred/dcrd/txscript/v4"
 	"github.com/decred/dcrd/txscript/v4/sign"
 	"github.com/decred/dcrd/txscript/v4/stdaddr"
 	"github.com/decred/dcrd/wire"
@@ -90,10 +86,17 @@ func isAlreadyHaveTxErr(err error) bool {
 type payout struct {
 	address stdaddr.StakeAddress
 	amount  dcrutil.Amount
+
+	// name is the name of the payee in the address book, if the payout
+	// referenced one.
+	name string
+
+	// fiat is the conversion of the payout from a fiat amount, if any.
+	fiat *fiatConversion
 }
 
 func payoutsFromCSV(cfg *config) ([]*payout, error) {
-	var payouts []*payout
+	var specs []*payoutSpec
 	f, err := os.Open(cfg.CSV)
 	if err != nil {
 		return nil, err
@@ -115,117 +118,86 @@ func payoutsFromCSV(cfg *config) ([]*payout, error) {
 				i, len(record))
 		}
 
-		// Decode address.
-		addr, err := stdaddr.DecodeAddress(record[0], cfg.chainParams)
+		// Decode address or payee name.
+		name, stakeAddr, err := resolvePayee(cfg, record[0])
 		if err != nil {
-			return nil, fmt.Errorf("record %d[0] is not an address: %v",
-				i, err)
-		}
-		stakeAddr, ok := addr.(stdaddr.StakeAddress)
-		if !ok {
-			return nil, fmt.Errorf("record %d[0] is not a stakeable "+
-				"address (%T)", i, addr)
+			return nil, fmt.Errorf("record %d[0] is not a valid "+
+				"address: %v", i, err)
 		}
 
-		amtFloat, err := strconv.ParseFloat(record[1], 64)
-		if err != nil {
-			return nil, fmt.Errorf("record %d[1] is not a float: %v",
-				i, err)
-		}
-		amt, err := dcrutil.NewAmount(amtFloat)
+		spec, err := parseAmountSpec(stakeAddr, record[1])
 		if err != nil {
-			return nil, fmt.Errorf("record %d[1] is not a dcr "+
+			return nil, fmt.Errorf("record %d[1] is not a valid "+
 				"amount: %v", i, err)
 		}
+		spec.name = name
 
-		payouts = append(payouts, &payout{
-			address: stakeAddr,
-			amount:  amt,
-		})
+		specs = append(specs, spec)
 	}
 
-	return payouts, nil
+	return resolvePayouts(cfg, specs, "")
 }
 
 func payoutsFromCfg(cfg *config) ([]*payout, error) {
-	payouts := make([]*payout, 0, len(cfg.Addresses))
+	specs := make([]*payoutSpec, 0, len(cfg.Addresses))
 
 	for i, encodedAddr := range cfg.Addresses {
-		amtFloat := cfg.Amounts[i]
-		amt, err := dcrutil.NewAmount(amtFloat)
+		// Decode address or payee name.
+		name, stakeAddr, err := resolvePayee(cfg, encodedAddr)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("address %d: %v", i, err)
 		}
 
-		// Decode address.
-		addr, err := stdaddr.DecodeAddress(encodedAddr, cfg.chainParams)
+		spec, err := parseAmountSpec(stakeAddr, cfg.Amounts[i])
 		if err != nil {
-			return nil, err
-		}
-		stakeAddr, ok := addr.(stdaddr.StakeAddress)
-		if !ok {
-			return nil, fmt.Errorf("address %d is not a stakeable "+
-				"address (%T)", i, addr)
+			return nil, fmt.Errorf("amount %d: %v", i, err)
 		}
+		spec.name = name
 
-		payouts = append(payouts, &payout{
-			address: stakeAddr,
-			amount:  amt,
-		})
+		specs = append(specs, spec)
 	}
-	return payouts, nil
+	return resolvePayouts(cfg, specs, "")
 }
 
 func loadPayouts(cfg *config) ([]*payout, error) {
-	if cfg.CSV != "" {
+	book, err := loadAddrBook(cfg.AddrBook, cfg.chainParams)
+	if err != nil {
+		return nil, err
+	}
+	cfg.addrBook = book
+
+	switch {
+	case cfg.CSV != "":
 		return payoutsFromCSV(cfg)
+	case cfg.JSON != "":
+		return payoutsFromJSON(cfg)
 	}
 
 	return payoutsFromCfg(cfg)
 }
 
 func loadOpReturnScript(cfg *config, payouts []*payout, totalPayout uint64) ([]byte, error) {
+	var extra []byte
 	var err error
-	randPayload := make([]byte, chainhash.HashSize)
-
-	// Encode the total payout.
-	binary.LittleEndian.PutUint64(randPayload, totalPayout)
-
-	// Read the random data.
-	if cfg.DeterministicOpReturn {
-		h := blake256.New()
-		h.Write([]byte("tspend OP_RETURN"))
-		var ab [8]byte
-		for _, p := range payouts {
-			version, script := p.address.PayFromTreasuryScript()
-			binary.LittleEndian.PutUint64(ab[:], uint64(p.amount))
-			h.Write(ab[:])
-			h.Write([]byte{byte(version << 8), byte(version)})
-			h.Write(script)
-		}
-
-		if cfg.OpReturnData != "" {
-			var extra [32]byte
-			n, err := hex.Decode(extra[:], []byte(cfg.OpReturnData))
-			if err != nil {
-				return nil, fmt.Errorf("unable to decode OP_RETURN data: %v", err)
-			}
-			h.Write(extra[:n])
-		}
-		hash := h.Sum(nil)
-		copy(randPayload[8:], hash)
-	} else if cfg.OpReturnData != "" {
-		_, err = hex.Decode(randPayload[8:], []byte(cfg.OpReturnData))
-	} else {
-		_, err = rand.Read(randPayload[8:])
+	switch {
+	case cfg.DeterministicOpReturn:
+		var data []byte
+		data, err = decodeOpReturnData(cfg.OpReturnData,
+			maxDeterministicExtraSize)
+		extra = deterministicOpReturnData(payouts, data)
+	case cfg.CommitManifest != "":
+		extra, err = loadManifestCommitment(cfg, cfg.CommitManifest,
+			payouts)
+	case cfg.OpReturnData != "":
+		extra, err = decodeOpReturnData(cfg.OpReturnData, opReturnExtraSize)
+	default:
+		extra = make([]byte, opReturnExtraSize)
+		_, err = rand.Read(extra)
 	}
 	if err != nil {
 		return nil, err
 	}
-	builder := txscript.NewScriptBuilder()
-	builder.AddOp(txscript.OP_RETURN)
-	builder.AddData(randPayload)
-	return builder.Script()
+	return opReturnScript(totalPayout, extra)
 }
 
 func loadExpiry(cfg *config, c *rpcclient.Client, ctx context.Context) (uint32, error) {
@@ -274,37 +246,10 @@ func loadExpiry(cfg *config, c *rpcclient.Client, ctx context.Context) (uint32,
 	return expiry, nil
 }
 
-func genTspend(cfg *config, ctx context.Context) error {
-	chainParams := cfg.chainParams
-	relayFee := dcrutil.Amount(cfg.FeeRate)
-
-	var c *rpcclient.Client
-	var err error
-
-	if cfg.needsDcrd() {
-		c, err = rpcclient.New(cfg.dcrdConnConfig(), nil)
-	}
-	if err != nil {
-		return err
-	}
-
-	// Figure out the expiry.
-	expiry, err := loadExpiry(cfg, c, ctx)
-	if err != nil {
-		return err
-	}
-
-	// Load the payouts.
-	var totalPayout dcrutil.Amount
-	payouts, err := loadPayouts(cfg)
-	if err != nil {
-		return err
-	}
-	if len(payouts) == 0 {
-		return fmt.Errorf("at least one payout must be specified")
-	}
-
-	// Start building the TSpend Tx.
+// newTSpendTx creates a tspend paying out to the given payouts. Its OP_RETURN
+// output has a pseudo script of the right size and its input is not yet
+// filled, so that the fee can be estimated from its size.
+func newTSpendTx(payouts []*payout, expiry uint32) *wire.MsgTx {
 	msgTx := wire.NewMsgTx()
 	msgTx.Version = wire.TxVersionTreasury
 	msgTx.Expiry = expiry
@@ -315,25 +260,16 @@ func genTspend(cfg *config, ctx context.Context) error {
 	var emptyOpRetScript [1 + 1 + 32]byte
 	msgTx.AddTxOut(wire.NewTxOut(0, emptyOpRetScript[:]))
 
-	// Generate OP_TGENs outputs and calculate totals.
+	// Generate OP_TGENs outputs.
 	for _, payout := range payouts {
-		totalPayout += payout.amount
-
 		// Create OP_TGEN prefixed script.
 		version, script := payout.address.PayFromTreasuryScript()
 
-		txOut := &wire.TxOut{
+		msgTx.AddTxOut(&wire.TxOut{
 			Value:    int64(payout.amount),
 			Version:  version,
 			PkScript: script,
-		}
-		if err := CheckOutput(txOut, relayFee); err != nil {
-			log.Warnf("Output %s (%d atoms) failed check: %v",
-				payout.address.String(), payout.amount, err)
-		}
-
-		// Add to transaction.
-		msgTx.AddTxOut(txOut)
+		})
 	}
 
 	// Add the base TxIn.
@@ -349,53 +285,226 @@ func genTspend(cfg *config, ctx context.Context) error {
 		SignatureScript: []byte{}, // Empty for now
 	})
 
+	return msgTx
+}
+
+// tspendFee returns the fee and the estimated size of the given tspend once
+// signed. The size of the outputs does not depend on their amounts, so this
+// may be called before the final amounts are known.
+func tspendFee(msgTx *wire.MsgTx, relayFee dcrutil.Amount) (dcrutil.Amount, int) {
 	// Estimate the size. It's the size of the tx so far + the signature of
 	// a TSPend which also has a fixed size.
 	estimatedSize := msgTx.SerializeSize() + tspend_sigscript_size
 
 	// Calculate fee. Inputs are <signature> <compressed key> OP_TSPEND.
-	fee := FeeForSerializeSize(relayFee, estimatedSize)
+	return FeeForSerializeSize(relayFee, estimatedSize), estimatedSize
+}
+
+// buildTSpend builds the unsigned tspend paying out to the given payouts. It
+// returns the tspend along with its fee and estimated signed size.
+func buildTSpend(cfg *config, payouts []*payout, expiry uint32) (*wire.MsgTx, dcrutil.Amount, int, error) {
+	relayFee := dcrutil.Amount(cfg.FeeRate)
+
+	// Start building the TSpend Tx.
+	msgTx := newTSpendTx(payouts, expiry)
+
+	// Calculate totals.
+	var totalPayout dcrutil.Amount
+	for i, payout := range payouts {
+		totalPayout += payout.amount
+		if err := CheckOutput(msgTx.TxOut[i+1], relayFee); err != nil {
+			return nil, 0, 0, fmt.Errorf("output %s (%d atoms) failed "+
+				"check: %v", payout.address.String(), payout.amount, err)
+		}
+	}
+
+	fee, estimatedSize := tspendFee(msgTx, relayFee)
 
 	// Fill in the value in with the fee.
 	valueInAmt := totalPayout + fee
 	msgTx.TxIn[0].ValueIn = int64(valueInAmt)
 
 	// Figure out the real OP_RETURN script that encodes the value in.
+	var err error
 	msgTx.TxOut[0].PkScript, err = loadOpReturnScript(cfg, payouts, uint64(valueInAmt))
 	if err != nil {
-		return err
+		return nil, 0, 0, err
 	}
 
+	return msgTx, fee, estimatedSize, nil
+}
+
+// signTSpends signs the tspends with the configured private key and returns
+// the public key that corresponds to it. The private key is only loaded once,
+// regardless of the number of tspends.
+func signTSpends(cfg *config, msgTxs ...*wire.MsgTx) ([]byte, error) {
 	// Load the priv key.
 	var privKeyBytes [32]byte
 	if err := loadPrivKey(cfg, &privKeyBytes); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Calculate TSpend signature without SigHashType. Zero out the
+	// Calculate TSpend signatures without SigHashType. Zero out the
 	// privKeyBytes afterwards as they won't be needed anymore.
-	sigscript, err := sign.TSpendSignatureScript(msgTx, privKeyBytes[:])
+	for _, msgTx := range msgTxs {
+		sigscript, err := sign.TSpendSignatureScript(msgTx, privKeyBytes[:])
+		if err != nil {
+			zeroBytes(privKeyBytes[:])
+			return nil, err
+		}
+		msgTx.TxIn[0].SignatureScript = sigscript
+	}
 	zeroBytes(privKeyBytes[:])
+
+	var pubKeyBytes []byte
+	for _, msgTx := range msgTxs {
+		var err error
+		pubKeyBytes, err = CheckSignedTSpend(msgTx, cfg.chainParams)
+		if err != nil {
+			return nil, fmt.Errorf("tspend %s failed verification: %v",
+				msgTx.TxHash(), err)
+		}
+	}
+	return pubKeyBytes, nil
+}
+
+// isPiKey returns true if the given public key is one of the Pi keys of the
+// chain.
+func isPiKey(chainParams *chaincfg.Params, pubKey []byte) bool {
+	for _, piKey := range chainParams.PiKeys {
+		if bytes.Equal(pubKey, piKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func genTspend(cfg *config, ctx context.Context) error {
+	chainParams := cfg.chainParams
+
+	var c *rpcclient.Client
+	var err error
+
+	if cfg.needsDcrd() {
+		c, err = rpcclient.New(cfg.dcrdConnConfig(), nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Figure out the expiry.
+	expiry, err := loadExpiry(cfg, c, ctx)
 	if err != nil {
 		return err
 	}
-	msgTx.TxIn[0].SignatureScript = sigscript
 
-	_, pubKeyBytes, err := stake.CheckTSpend(msgTx)
+	// Load the payouts.
+	payouts, err := loadPayouts(cfg)
 	if err != nil {
-		return fmt.Errorf("CheckTSPend failed: %v", err)
+		return err
+	}
+	if len(payouts) == 0 {
+		return fmt.Errorf("at least one payout must be specified")
 	}
 
-	// Determine the corresponding public key for debug reasons.
-	var foundPiKey bool
-	for i := 0; i < len(chainParams.PiKeys) && !foundPiKey; i++ {
-		foundPiKey = foundPiKey || bytes.Equal(pubKeyBytes, chainParams.PiKeys[i])
+	// Split large grants across a vesting schedule if requested.
+	if cfg.Installments > 1 {
+		err := genVestingSchedule(ctx, c, cfg, payouts, expiry)
+		c.Shutdown()
+		return err
+	}
+
+	// Scale the payouts to the spendable amount if requested.
+	if cfg.AutoScale {
+		payouts, err = autoScalePayouts(ctx, c, cfg, payouts, expiry)
+		if err != nil {
+			return err
+		}
+		if err := confirmPayouts(cfg, payouts); err != nil {
+			return err
+		}
 	}
 
+	msgTx, fee, estimatedSize, err := buildTSpend(cfg, payouts, expiry)
+	if err != nil {
+		return err
+	}
+	totalPayout := dcrutil.Amount(msgTx.TxIn[0].ValueIn) - fee
+
+	// Enforce the signing policy before the private key is loaded.
+	err = enforceSigningPolicy(ctx, c, cfg, [][]*payout{payouts},
+		[]uint32{expiry})
+	if err != nil {
+		return err
+	}
+
+	// Reproduce the tspend already issued for the same payouts, if any,
+	// instead of generating a conflicting one.
+	issued, err := loadIssuedState(cfg)
+	if err != nil {
+		return err
+	}
+	setHash := payoutSetHash(payouts)
+	prev, err := checkIssued(ctx, c, cfg, issued, setHash, expiry, "")
+	if err != nil {
+		return err
+	}
+	if prev != nil {
+		prevTx, err := decodeIssued(prev)
+		if err != nil {
+			return err
+		}
+		err = checkReproduced(cfg, payouts, prev, prevTx, msgTx)
+		if err != nil {
+			return err
+		}
+		msgTx = prevTx
+		fee = txFee(msgTx)
+		totalPayout = dcrutil.Amount(msgTx.TxIn[0].ValueIn) - fee
+		estimatedSize = msgTx.SerializeSize()
+		log.Infof("Reproducing tspend %s previously issued for the same "+
+			"payouts", prev.TxHash)
+	}
+
+	// Write the tspend for review instead of signing it if requested or
+	// required by the trusted reviewers.
+	if cfg.Unsigned != "" {
+		if c != nil {
+			c.Shutdown()
+		}
+		msgTx.TxIn[0].SignatureScript = nil
+		return writeUnsignedManifest(cfg, msgTx, payouts, fee)
+	}
+
+	var pubKeyBytes []byte
+	if prev != nil {
+		pubKeyBytes, err = CheckSignedTSpend(msgTx, chainParams)
+		if err != nil {
+			return fmt.Errorf("tspend %s failed verification: %v",
+				msgTx.TxHash(), err)
+		}
+	} else {
+		if err := requireNoReviewers(cfg); err != nil {
+			return err
+		}
+		if pubKeyBytes, err = signTSpends(cfg, msgTx); err != nil {
+			return err
+		}
+		if err := issued.record(setHash, msgTx, false); err != nil {
+			return err
+		}
+	}
+
+	// Determine the corresponding public key for debug reasons.
+	foundPiKey := isPiKey(chainParams, pubKeyBytes)
+
 	// Publish the tx if requested.
 	published, duplicated := false, false
 
 	if cfg.Publish {
+		if err := checkDuplicateTSpends(ctx, c, cfg, msgTx, payouts); err != nil {
+			return err
+		}
 		_, err := c.SendRawTransaction(ctx, msgTx, true)
 		if err != nil {
 			if isAlreadyHaveTxErr(err) {
@@ -406,25 +515,16 @@ func genTspend(cfg *config, ctx context.Context) error {
 		} else {
 			published = true
 		}
+		if err := issued.record(setHash, msgTx, true); err != nil {
+			return err
+		}
 	}
 
 	// Write the raw tx.
-	rawTx, err := msgTx.Bytes()
-	if err != nil {
+	if err := writeTSpends(cfg, msgTx); err != nil {
 		return err
 	}
 
-	if cfg.Out != "" {
-		f, err := os.Create(cfg.Out)
-		if err != nil {
-			return fmt.Errorf("error creating output file: %v", err)
-		}
-		fmt.Fprintf(f, "%x\n", rawTx)
-		f.Close()
-	} else {
-		fmt.Printf("%x\n", rawTx)
-	}
-
 	// Debug stuff.
 	debugf := func(format string, args ...interface{}) {
 		log.Infof(format, args...)
@@ -442,7 +542,14 @@ func genTspend(cfg *config, ctx context.Context) error {
 	debugf("TSpend PubKey: %x", pubKeyBytes)
 	debugf("Expiry: %d", expiry)
 	debugf("Voting interval: %d - %d", start, end)
+	for _, p := range payouts {
+		debugf("Payout to %s: %s (fingerprint %s)",
+			payeeString(p.name, p.address), p.amount,
+			addrFingerprint(p.address))
+	}
 	debugf("Total output amount: %s", totalPayout)
+	logConversions(payouts)
+	logMeta(cfg.meta)
 	debugf("Total tx size: %d bytes", estimatedSize)
 	debugf("Total fees: %s", dcrutil.Amount(fee))
 	if published {
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/wire"
+)
+
+// manifestPayout is a payout as recorded in a manifest.
+type manifestPayout struct {
+	Name        string         `json:"name,omitempty"`
+	Address     string         `json:"address"`
+	Fingerprint string         `json:"fingerprint"`
+	Amount      dcrutil.Amount `json:"amount"`
+}
+
+// installment is one of the tspends of a vesting schedule.
+type installment struct {
+	Index              int              `json:"index"`
+	Expiry             uint32           `json:"expiry"`
+	VoteStart          uint32           `json:"votestart"`
+	VoteEnd            uint32           `json:"voteend"`
+	TxHash             string           `json:"txhash"`
+	Total              dcrutil.Amount   `json:"total"`
+	Fee                dcrutil.Amount   `json:"fee"`
+	ProjectedAllowance dcrutil.Amount   `json:"projectedallowance"`
+	Payouts            []manifestPayout `json:"payouts"`
+	Tx                 string           `json:"tx"`
+
+	msgTx *wire.MsgTx
+}
+
+// scheduleManifest links each installment of a vesting schedule to its tspend.
+type scheduleManifest struct {
+	Network      string         `json:"network"`
+	CreatedAt    time.Time      `json:"createdat"`
+	PiKey        string         `json:"pikey"`
+	WindowStep   int            `json:"windowstep"`
+	Total        dcrutil.Amount `json:"total"`
+	Installments []*installment `json:"installments"`
+
+	// Metadata are the metadata fields given when generating the schedule.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Conversions are the fiat conversions of the total payouts that were
+	// split across the installments.
+	Conversions []*fiatConversion `json:"conversions,omitempty"`
+}
+
+// splitInstallments splits the amount of each payout across n installments.
+// Every installment receives the floor of the amount divided by n and the
+// atoms left over are added, one each, to the first installments.
+func splitInstallments(payouts []*payout, n int) ([][]*payout, error) {
+	parts := make([][]*payout, n)
+	for _, p := range payouts {
+		base := p.amount / dcrutil.Amount(n)
+		extra := int(p.amount % dcrutil.Amount(n))
+		if base == 0 {
+			return nil, fmt.Errorf("amount %s of payout to %s is too "+
+				"small to split in %d installments", p.amount,
+				p.address, n)
+		}
+		for k := range parts {
+			amount := base
+			if k < extra {
+				amount++
+			}
+			parts[k] = append(parts[k], &payout{
+				address: p.address,
+				amount:  amount,
+				name:    p.name,
+			})
+		}
+	}
+	return parts, nil
+}
+
+// genVestingSchedule generates one tspend per installment of the vesting
+// schedule, with expiries in successive (or every Nth) voting windows starting
+// at the given expiry. Each installment is checked against the allowance
+// projected for the end of its voting window before anything is signed.
+func genVestingSchedule(ctx context.Context, c *rpcclient.Client, cfg *config,
+	payouts []*payout, expiry uint32) error {
+
+	chainParams := cfg.chainParams
+	tvi := chainParams.TreasuryVoteInterval
+	mul := chainParams.TreasuryVoteIntervalMultiplier
+	policyWindow := int64(tvi * mul * chainParams.TreasuryExpenditureWindow)
+
+	parts, err := splitInstallments(payouts, cfg.Installments)
+	if err != nil {
+		return err
+	}
+
+	estimator, err := loadTreasuryEstimator(ctx, c, chainParams)
+	if err != nil {
+		return err
+	}
+
+	manifest := &scheduleManifest{
+		Network:     chainParams.Name,
+		CreatedAt:   time.Now().UTC(),
+		WindowStep:  cfg.WindowStep,
+		Metadata:    cfg.meta,
+		Conversions: fiatConversions(payouts),
+	}
+	msgTxs := make([]*wire.MsgTx, 0, len(parts))
+	var failed int
+	for k, part := range parts {
+		inst := &installment{Index: k + 1}
+		inst.Expiry = expiry + uint32(uint64(k*cfg.WindowStep)*tvi*mul)
+		inst.VoteStart, inst.VoteEnd, err = blockchain.CalcTSpendWindow(
+			inst.Expiry, tvi, mul)
+		if err != nil {
+			return fmt.Errorf("installment %d: %v", inst.Index, err)
+		}
+
+		inst.msgTx, inst.Fee, _, err = buildTSpend(cfg, part, inst.Expiry)
+		if err != nil {
+			return err
+		}
+		valueIn := dcrutil.Amount(inst.msgTx.TxIn[0].ValueIn)
+		inst.Total = valueIn - inst.Fee
+		for _, p := range part {
+			inst.Payouts = append(inst.Payouts, manifestPayout{
+				Name:        p.name,
+				Address:     p.address.String(),
+				Fingerprint: addrFingerprint(p.address),
+				Amount:      p.amount,
+			})
+		}
+
+		// Earlier installments still in the policy window count
+		// against this one.
+		inst.ProjectedAllowance = estimator.spendableAt(int64(inst.VoteEnd))
+		for _, prev := range manifest.Installments {
+			blocks := int64(inst.VoteEnd) - int64(prev.VoteEnd)
+			if blocks < policyWindow {
+				inst.ProjectedAllowance -= prev.Total + prev.Fee
+			}
+		}
+		if valueIn > inst.ProjectedAllowance {
+			log.Errorf("Installment %d of %s (expiry %d) exceeds the "+
+				"projected allowance of %s at block %d", inst.Index,
+				valueIn, inst.Expiry, inst.ProjectedAllowance,
+				inst.VoteEnd)
+			failed++
+		}
+
+		manifest.Total += inst.Total
+		manifest.Installments = append(manifest.Installments, inst)
+		msgTxs = append(msgTxs, inst.msgTx)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d installments exceed the projected "+
+			"allowance; use more installments or a larger window step",
+			failed)
+	}
+
+	// Enforce the signing policy on every installment before the private
+	// key is loaded.
+	expiries := make([]uint32, len(manifest.Installments))
+	for i, inst := range manifest.Installments {
+		expiries[i] = inst.Expiry
+	}
+	if err := enforceSigningPolicy(ctx, c, cfg, parts, expiries); err != nil {
+		return err
+	}
+	if err := requireNoReviewers(cfg); err != nil {
+		return err
+	}
+
+	// Refuse to generate installments that conflict with tspends already
+	// issued for the same payouts.
+	issued, err := loadIssuedState(cfg)
+	if err != nil {
+		return err
+	}
+	setHashes := make([]string, len(parts))
+	for i, inst := range manifest.Installments {
+		setHashes[i] = installmentSetHash(payouts, parts[i], i,
+			len(parts))
+		_, err := checkIssued(ctx, c, cfg, issued, setHashes[i],
+			inst.Expiry, inst.msgTx.TxHash().String())
+		if err != nil {
+			return fmt.Errorf("installment %d: %v", inst.Index, err)
+		}
+	}
+
+	pubKeyBytes, err := signTSpends(cfg, msgTxs...)
+	if err != nil {
+		return err
+	}
+	manifest.PiKey = hex.EncodeToString(pubKeyBytes)
+	for i, inst := range manifest.Installments {
+		if err := issued.record(setHashes[i], inst.msgTx, false); err != nil {
+			return err
+		}
+		rawTx, err := inst.msgTx.Bytes()
+		if err != nil {
+			return err
+		}
+		inst.TxHash = inst.msgTx.TxHash().String()
+		inst.Tx = hex.EncodeToString(rawTx)
+	}
+
+	if err := writeManifest(cfg.Manifest, manifest); err != nil {
+		return err
+	}
+	if err := writeTSpends(cfg, msgTxs...); err != nil {
+		return err
+	}
+
+	for _, inst := range manifest.Installments {
+		log.Infof("Installment %d: %s (expiry %d, voting interval %d - %d, "+
+			"allowance %s) TSpend Hash: %s", inst.Index, inst.Total,
+			inst.Expiry, inst.VoteStart, inst.VoteEnd,
+			inst.ProjectedAllowance, inst.TxHash)
+	}
+	logConversions(payouts)
+	logMeta(cfg.meta)
+	log.Infof("Wrote schedule manifest to %s", cfg.Manifest)
+	if !isPiKey(chainParams, pubKeyBytes) {
+		log.Warnf("Private key does not correspond to a public Pi Key " +
+			"for the specified chain")
+	}
+	return nil
+}
+
+// writeManifest writes v as an indented JSON document to the given path.
+func writeManifest(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return fmt.Errorf("unable to write manifest: %v", err)
+	}
+	return nil
+}
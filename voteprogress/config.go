@@ -23,7 +23,7 @@ const (
 	cnSimNet  chainNetwork = "simnet"
 )
 
-// defaultDcrdCfg returns the default rpc connect address for the given
+// defaultDcrdRPCConnect returns the default rpc connect address for the given
 // network.
 func (c chainNetwork) defaultDcrdRPCConnect() string {
 	switch c {
@@ -84,6 +84,11 @@ type config struct {
 	DcrdUser      string `short:"u" long:"dcrduser" description:"RPC username to authenticate with dcrd"`
 	DcrdPass      string `short:"P" long:"dcrdpass" description:"RPC password to authenticate with dcrd"`
 
+	// Output Options
+
+	TSpend string `long:"tspend" description:"Only show the TSpend with the specified hash instead of all mempool TSpends"`
+	ICS    string `long:"ics" description:"Write the voting window events of the TSpends to the specified iCalendar (.ics) file"`
+
 	// The rest of the members of this struct are filled by loadConfig().
 
 	activeNet   chainNetwork
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/chaincfg/v3"
 	chainjson "github.com/decred/dcrd/rpc/jsonrpc/types/v4"
 	"github.com/decred/dcrd/rpcclient/v8"
@@ -206,6 +207,20 @@ func voteProgress(cfg *config, ctx context.Context) error {
 		return fmt.Errorf("no tspends in dcrd mempool")
 	}
 
+	if cfg.TSpend != "" {
+		var found bool
+		for _, tspend := range tspends.Votes {
+			if tspend.Hash == cfg.TSpend {
+				tspends.Votes = []chainjson.TreasurySpendVotes{tspend}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("tspend %s not found in dcrd mempool", cfg.TSpend)
+		}
+	}
+
 	fmt.Printf("Checking %d tspends at block %d (%s)\n", len(tspends.Votes),
 		tspends.Height, tspends.Hash)
 
@@ -216,5 +231,43 @@ func voteProgress(cfg *config, ctx context.Context) error {
 		}
 	}
 
+	if cfg.ICS != "" {
+		err := writeVotesICS(ctx, c, cfg, tspends)
+		if err != nil {
+			return fmt.Errorf("unable to write ics file: %v", err)
+		}
+		fmt.Printf("\nWrote voting calendar to %s\n", cfg.ICS)
+	}
+
 	return nil
 }
+
+// writeVotesICS writes the calendar events for the voting windows of the
+// given tspends. Times are estimated from the timestamp of the block the votes
+// were tallied at.
+func writeVotesICS(ctx context.Context, c *rpcclient.Client, cfg *config,
+	tspends *chainjson.GetTreasurySpendVotesResult) error {
+
+	tipHash, err := chainhash.NewHashFromStr(tspends.Hash)
+	if err != nil {
+		return err
+	}
+	tipHeader, err := c.GetBlockHeader(ctx, tipHash)
+	if err != nil {
+		return err
+	}
+	heightTime := func(height int64) time.Time {
+		blocks := height - tspends.Height
+		return tipHeader.Timestamp.Add(time.Duration(blocks) *
+			cfg.chainParams.TargetTimePerBlock)
+	}
+
+	var events []icsEvent
+	for _, tspend := range tspends.Votes {
+		label := fmt.Sprintf("TSpend %s", tspend.Hash[:16])
+		events = append(events, tspendEvents(cfg.chainParams, heightTime,
+			tspend.Hash, label, uint32(tspend.Expiry),
+			uint32(tspend.VoteStart), uint32(tspend.VoteEnd))...)
+	}
+	return writeICS(cfg.ICS, events)
+}